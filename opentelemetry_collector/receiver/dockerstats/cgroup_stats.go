@@ -0,0 +1,358 @@
+package dockerstats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// cgroupStatsSource reads the resource usage stats of containers directly
+// from the cgroup filesystem, without going through the docker daemon. Both
+// the cgroupfs and systemd cgroup drivers are supported, on cgroup v1 and v2.
+//
+// The cgroup filesystem does not expose network stats, and the CPU
+// utilization cannot be computed as there is no previous sample.
+type cgroupStatsSource struct {
+	// root is the mount point of the cgroup filesystem, e.g. /sys/fs/cgroup.
+	root string
+	now  func() time.Time
+}
+
+func (c *cgroupStatsSource) isV2() bool {
+	_, err := os.Stat(filepath.Join(c.root, "cgroup.controllers"))
+	return err == nil
+}
+
+func (c *cgroupStatsSource) containerStats(ctx context.Context, id string) (*usageStats, error) {
+	stats := &usageStats{StatsJSON: types.StatsJSON{ID: id}}
+	stats.Read = c.now()
+
+	var err error
+	if c.isV2() {
+		err = c.readV2(id, &stats.Stats)
+	} else {
+		err = c.readV1(id, &stats.Stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// listContainers returns the containers that have a cgroup. Only their ID is
+// known.
+func (c *cgroupStatsSource) listContainers() ([]types.Container, error) {
+	base := c.root
+	if !c.isV2() {
+		base = filepath.Join(c.root, "memory")
+	}
+
+	var containers []types.Container
+	for _, pattern := range []string{
+		filepath.Join(base, "docker", "*"),
+		filepath.Join(base, "system.slice", "docker-*.scope"),
+	} {
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list container cgroups: %v", err)
+		}
+		for _, dir := range dirs {
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				continue
+			}
+			id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(dir), "docker-"), ".scope")
+			containers = append(containers, types.Container{ID: id, State: "running"})
+		}
+	}
+	return containers, nil
+}
+
+// containerDir returns the cgroup directory of the container under base, the
+// cgroup root (v2) or the mount point of a controller (v1).
+func containerDir(base, id string) (string, error) {
+	for _, dir := range []string{
+		filepath.Join(base, "docker", id),
+		filepath.Join(base, "system.slice", "docker-"+id+".scope"),
+	} {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup found for container under %s", base)
+}
+
+// readV1 reads the stats of the container from the cgroup v1 hierarchies.
+// The memory controller is required, the others are read if mounted.
+func (c *cgroupStatsSource) readV1(id string, stats *types.Stats) error {
+	dir, err := containerDir(filepath.Join(c.root, "memory"), id)
+	if err != nil {
+		return err
+	}
+	mem := &stats.MemoryStats
+	if mem.Usage, err = readUint(filepath.Join(dir, "memory.usage_in_bytes")); err != nil {
+		return err
+	}
+	if mem.Limit, err = readUint(filepath.Join(dir, "memory.limit_in_bytes")); err != nil {
+		return err
+	}
+	if mem.MaxUsage, err = readUint(filepath.Join(dir, "memory.max_usage_in_bytes")); err != nil {
+		return err
+	}
+	if mem.Failcnt, err = readUint(filepath.Join(dir, "memory.failcnt")); err != nil {
+		return err
+	}
+	if mem.Stats, err = readKeyValues(filepath.Join(dir, "memory.stat")); err != nil {
+		return err
+	}
+
+	if dir, err := containerDir(filepath.Join(c.root, "cpuacct"), id); err == nil {
+		cpu := &stats.CPUStats.CPUUsage
+		if cpu.TotalUsage, err = readUint(filepath.Join(dir, "cpuacct.usage")); err != nil {
+			return err
+		}
+		if cpu.PercpuUsage, err = readUints(filepath.Join(dir, "cpuacct.usage_percpu")); err != nil {
+			return err
+		}
+	}
+
+	if dir, err := containerDir(filepath.Join(c.root, "cpu"), id); err == nil {
+		cpuStat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+		if err != nil {
+			return err
+		}
+		stats.CPUStats.ThrottlingData = types.ThrottlingData{
+			Periods:          cpuStat["nr_periods"],
+			ThrottledPeriods: cpuStat["nr_throttled"],
+			ThrottledTime:    cpuStat["throttled_time"],
+		}
+	}
+
+	if dir, err := containerDir(filepath.Join(c.root, "blkio"), id); err == nil {
+		blkio := &stats.BlkioStats
+		if blkio.IoServiceBytesRecursive, err = readBlkioV1(filepath.Join(dir, "blkio.throttle.io_service_bytes")); err != nil {
+			return err
+		}
+		if blkio.IoServicedRecursive, err = readBlkioV1(filepath.Join(dir, "blkio.throttle.io_serviced")); err != nil {
+			return err
+		}
+	}
+
+	if dir, err := containerDir(filepath.Join(c.root, "pids"), id); err == nil {
+		if err := readPids(dir, &stats.PidsStats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readV2 reads the stats of the container from the cgroup v2 unified
+// hierarchy.
+func (c *cgroupStatsSource) readV2(id string, stats *types.Stats) error {
+	dir, err := containerDir(c.root, id)
+	if err != nil {
+		return err
+	}
+
+	mem := &stats.MemoryStats
+	if mem.Usage, err = readUint(filepath.Join(dir, "memory.current")); err != nil {
+		return err
+	}
+	if mem.Limit, err = readUint(filepath.Join(dir, "memory.max")); err != nil {
+		return err
+	}
+	if mem.Stats, err = readKeyValues(filepath.Join(dir, "memory.stat")); err != nil {
+		return err
+	}
+	// There is no failcnt in cgroup v2, and docker leaves it unset: it is not
+	// reported either, whatever the source of the stats.
+
+	cpuStat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return err
+	}
+	stats.CPUStats.CPUUsage = types.CPUUsage{
+		TotalUsage:        cpuStat["usage_usec"] * 1000,
+		UsageInUsermode:   cpuStat["user_usec"] * 1000,
+		UsageInKernelmode: cpuStat["system_usec"] * 1000,
+	}
+	stats.CPUStats.ThrottlingData = types.ThrottlingData{
+		Periods:          cpuStat["nr_periods"],
+		ThrottledPeriods: cpuStat["nr_throttled"],
+		ThrottledTime:    cpuStat["throttled_usec"] * 1000,
+	}
+
+	if err := readIOStatV2(filepath.Join(dir, "io.stat"), &stats.BlkioStats); err != nil {
+		return err
+	}
+	return readPids(dir, &stats.PidsStats)
+}
+
+func readPids(dir string, pids *types.PidsStats) error {
+	var err error
+	if pids.Current, err = readUint(filepath.Join(dir, "pids.current")); err != nil {
+		return err
+	}
+	pids.Limit, err = readUint(filepath.Join(dir, "pids.max"))
+	return err
+}
+
+// readUint reads a file containing a single unsigned integer. "max", which
+// means no limit, is read as 0.
+func readUint(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cgroup file: %v", err)
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return v, nil
+}
+
+// readUints reads a file containing space separated unsigned integers.
+func readUints(path string) ([]uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroup file: %v", err)
+	}
+	var values []uint64
+	for _, f := range strings.Fields(string(b)) {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// readKeyValues reads a flat keyed file with one "key value" pair per line,
+// such as memory.stat or cpu.stat.
+func readKeyValues(path string) (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	err := scanLines(path, func(fields []string) error {
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid line %q", strings.Join(fields, " "))
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		values[fields[0]] = v
+		return nil
+	})
+	return values, err
+}
+
+// readBlkioV1 reads a cgroup v1 blkio file with "major:minor op value" lines.
+func readBlkioV1(path string) ([]types.BlkioStatEntry, error) {
+	var entries []types.BlkioStatEntry
+	err := scanLines(path, func(fields []string) error {
+		// The last line is the total over all devices: "Total <value>".
+		if len(fields) != 3 {
+			return nil
+		}
+		major, minor, err := parseDevice(fields[0])
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, types.BlkioStatEntry{Major: major, Minor: minor, Op: fields[1], Value: v})
+		return nil
+	})
+	return entries, err
+}
+
+// readIOStatV2 reads the cgroup v2 io.stat file, with one
+// "major:minor rbytes=... wbytes=... rios=... wios=..." line per device.
+func readIOStatV2(path string, blkio *types.BlkioStats) error {
+	return scanLines(path, func(fields []string) error {
+		major, minor, err := parseDevice(fields[0])
+		if err != nil {
+			return err
+		}
+		for _, f := range fields[1:] {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			entry := types.BlkioStatEntry{Major: major, Minor: minor, Value: v}
+			switch kv[0] {
+			case "rbytes":
+				entry.Op = "Read"
+				blkio.IoServiceBytesRecursive = append(blkio.IoServiceBytesRecursive, entry)
+			case "wbytes":
+				entry.Op = "Write"
+				blkio.IoServiceBytesRecursive = append(blkio.IoServiceBytesRecursive, entry)
+			case "rios":
+				entry.Op = "Read"
+				blkio.IoServicedRecursive = append(blkio.IoServicedRecursive, entry)
+			case "wios":
+				entry.Op = "Write"
+				blkio.IoServicedRecursive = append(blkio.IoServicedRecursive, entry)
+			}
+		}
+		return nil
+	})
+}
+
+func parseDevice(s string) (uint64, uint64, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid device %q", s)
+	}
+	major, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid device %q: %v", s, err)
+	}
+	minor, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid device %q: %v", s, err)
+	}
+	return major, minor, nil
+}
+
+// scanLines calls fn with the whitespace separated fields of every non-empty
+// line of the file.
+func scanLines(path string, fn func(fields []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read cgroup file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := fn(fields); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return nil
+}
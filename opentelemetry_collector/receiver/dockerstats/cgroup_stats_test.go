@@ -0,0 +1,156 @@
+package dockerstats
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+)
+
+func TestCgroupStatsV1(t *testing.T) {
+	src := &cgroupStatsSource{root: "testdata/cgroup/v1", now: fakeNow}
+	stats, err := src.containerStats(context.Background(), "abc123")
+	require.NoError(t, err)
+
+	assert.Equal(t, "abc123", stats.ID)
+	assert.Equal(t, fakeNow(), stats.Read)
+	assert.Equal(t, uint64(104857600), stats.MemoryStats.Usage)
+	assert.Equal(t, uint64(536870912), stats.MemoryStats.Limit)
+	assert.Equal(t, uint64(209715200), stats.MemoryStats.MaxUsage)
+	assert.Equal(t, uint64(3), stats.MemoryStats.Failcnt)
+	assert.Equal(t, uint64(52428800), stats.MemoryStats.Stats["total_rss"])
+	assert.Equal(t, uint64(123456789), stats.CPUStats.CPUUsage.TotalUsage)
+	assert.Equal(t, []uint64{100000000, 23456789}, stats.CPUStats.CPUUsage.PercpuUsage)
+	assert.Equal(t, types.ThrottlingData{Periods: 100, ThrottledPeriods: 7, ThrottledTime: 5000000}, stats.CPUStats.ThrottlingData)
+	assert.Equal(t, []types.BlkioStatEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 4096},
+		{Major: 8, Minor: 0, Op: "Write", Value: 8192},
+		{Major: 8, Minor: 0, Op: "Sync", Value: 12288},
+		{Major: 8, Minor: 0, Op: "Async", Value: 0},
+		{Major: 8, Minor: 0, Op: "Total", Value: 12288},
+	}, stats.BlkioStats.IoServiceBytesRecursive)
+	assert.Len(t, stats.BlkioStats.IoServicedRecursive, 5)
+	assert.Equal(t, types.PidsStats{Current: 9}, stats.PidsStats)
+	assert.Nil(t, stats.Networks)
+}
+
+func TestCgroupStatsV2(t *testing.T) {
+	src := &cgroupStatsSource{root: "testdata/cgroup/v2", now: fakeNow}
+	stats, err := src.containerStats(context.Background(), "def456")
+	require.NoError(t, err)
+
+	assert.Equal(t, uint64(73400320), stats.MemoryStats.Usage)
+	assert.Equal(t, uint64(0), stats.MemoryStats.Limit)
+	assert.Equal(t, uint64(0), stats.MemoryStats.Failcnt)
+	assert.Equal(t, uint64(31457280), stats.MemoryStats.Stats["anon"])
+	assert.Equal(t, types.CPUUsage{TotalUsage: 2500000, UsageInUsermode: 2000000, UsageInKernelmode: 500000}, stats.CPUStats.CPUUsage)
+	assert.Equal(t, types.ThrottlingData{Periods: 50, ThrottledPeriods: 5, ThrottledTime: 300000}, stats.CPUStats.ThrottlingData)
+	assert.Equal(t, []types.BlkioStatEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 1024},
+		{Major: 8, Minor: 0, Op: "Write", Value: 2048},
+	}, stats.BlkioStats.IoServiceBytesRecursive)
+	assert.Equal(t, []types.BlkioStatEntry{
+		{Major: 8, Minor: 0, Op: "Read", Value: 3},
+		{Major: 8, Minor: 0, Op: "Write", Value: 4},
+	}, stats.BlkioStats.IoServicedRecursive)
+	assert.Equal(t, types.PidsStats{Current: 4, Limit: 256}, stats.PidsStats)
+}
+
+func TestCgroupStatsUnknownContainer(t *testing.T) {
+	for _, root := range []string{"testdata/cgroup/v1", "testdata/cgroup/v2", "testdata/cgroup/missing"} {
+		src := &cgroupStatsSource{root: root, now: fakeNow}
+		_, err := src.containerStats(context.Background(), "unknown")
+		assert.Error(t, err, root)
+	}
+}
+
+func TestCgroupListContainers(t *testing.T) {
+	src := &cgroupStatsSource{root: "testdata/cgroup/v1", now: fakeNow}
+	containers, err := src.listContainers()
+	require.NoError(t, err)
+	assert.Equal(t, []types.Container{{ID: "abc123", State: "running"}}, containers)
+
+	src = &cgroupStatsSource{root: "testdata/cgroup/v2", now: fakeNow}
+	containers, err = src.listContainers()
+	require.NoError(t, err)
+	assert.Equal(t, []types.Container{{ID: "def456", State: "running"}}, containers)
+}
+
+func TestScraperExportCgroupFallback(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	cgroups := &cgroupStatsSource{root: "testdata/cgroup/v2", now: fakeNow}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &alwaysFailDocker{},
+		stats:          cgroups,
+		cgroups:        cgroups,
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/memory/usage", "def456", 73400320)
+	verifyContainerMetricValue(t, data, "container/cpu/usage_time", "def456", 2500000)
+	verifyContainerMetricValue(t, data, "container/pids/current", "def456", 4)
+	verifyContainerMetricAbsent(t, data, "container/network/received_bytes", "def456")
+	verifyContainerMetricAbsent(t, data, "container/cpu/utilization", "def456")
+	// The container is not inspected while the daemon is down.
+	verifyContainerMetricAbsent(t, data, "container/restart_count", "def456")
+}
+
+// listOnceDocker lists the container of the v2 cgroup testdata until fail is
+// set, and fails to inspect it.
+type listOnceDocker struct {
+	client.Client
+	fail bool
+}
+
+func (d *listOnceDocker) ContainerList(_ context.Context, _ types.ContainerListOptions) ([]types.Container, error) {
+	if d.fail {
+		return nil, fmt.Errorf("daemon not responding")
+	}
+	return []types.Container{
+		{ID: "def456", Names: []string{"/web"}, State: "running"},
+		{ID: "ghi789", Names: []string{"/db"}, State: "exited"},
+	}, nil
+}
+
+func (d *listOnceDocker) ContainerInspect(_ context.Context, _ string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{}, fmt.Errorf("not implemented")
+}
+
+func TestScraperExportCgroupFallbackLastList(t *testing.T) {
+	include, err := newContainerFilter(&ContainerFilter{Names: []string{"^web$"}})
+	require.NoError(t, err)
+	c := &fakeMetricsConsumer{}
+	docker := &listOnceDocker{}
+	cgroups := &cgroupStatsSource{root: "testdata/cgroup/v2", now: fakeNow}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         docker,
+		stats:          cgroups,
+		cgroups:        cgroups,
+		include:        include,
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+	docker.fail = true
+	s.export(context.Background())
+
+	// The container keeps its listed name and is still selected by it.
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/memory/usage", "web", 73400320)
+	verifyContainerMetricAbsent(t, data, "container/memory/usage", "def456")
+}
@@ -6,9 +6,136 @@ import (
 	"go.opentelemetry.io/collector/config/configmodels"
 )
 
+// Stats collection modes.
+const (
+	modePoll   = "poll"
+	modeStream = "stream"
+	modeCgroup = "cgroup"
+)
+
+// Handling of the network stats of containers sharing a network namespace.
+const (
+	sharedNetworkReport   = "report"
+	sharedNetworkLabel    = "label"
+	sharedNetworkSuppress = "suppress"
+)
+
 // Config defines the configuration for dockerstats receiver.
 type Config struct {
 	configmodels.ReceiverSettings `mapstructure:",squash"`
 	// ScrapeInterval controls how often docker stats are scraped from docker API.
 	ScrapeInterval time.Duration `mapstructure:"scrape_interval"`
+	// Endpoint is the address of the docker daemon, e.g.
+	// "unix:///var/run/docker.sock" or "tcp://10.0.0.1:2376". When Endpoint,
+	// APIVersion and TLS are all unset, the client is configured from the
+	// DOCKER_HOST, DOCKER_API_VERSION, DOCKER_CERT_PATH and DOCKER_TLS_VERIFY
+	// environment variables.
+	Endpoint string `mapstructure:"endpoint"`
+	// APIVersion is the version of the docker API requested, e.g. "1.25".
+	APIVersion string `mapstructure:"api_version"`
+	// TLS enables TLS for the connection to Endpoint.
+	TLS *TLSConfig `mapstructure:"tls"`
+	// Timeout bounds the docker API requests of a scrape. It defaults to the
+	// scrape interval.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Mode selects how container stats are read from the docker API: "poll"
+	// requests a single sample of every container on each scrape, "stream"
+	// keeps a streaming stats request open per container and scrapes report
+	// the latest sample received, "cgroup" reads the stats from the cgroup
+	// filesystem under CgroupRoot. The cgroup mode reports no network or CPU
+	// utilization metrics.
+	Mode string `mapstructure:"mode"`
+	// CgroupRoot is the path where the host cgroup filesystem is mounted,
+	// used in cgroup mode.
+	CgroupRoot string `mapstructure:"cgroup_root"`
+	// WatchEvents subscribes to the docker events API and reports the number
+	// of start, die, oom, kill and health_status events of each container.
+	// The counts of a container are dropped an hour after it was last listed
+	// or had an event.
+	WatchEvents bool `mapstructure:"watch_events"`
+	// MaxConcurrentScrapes is the number of containers whose stats are read
+	// from the docker API in parallel.
+	MaxConcurrentScrapes int `mapstructure:"max_concurrent_scrapes"`
+	// PerInterfaceNetwork reports network metrics separately for each network
+	// interface of a container, with an interface label. By default the stats
+	// of all interfaces are summed.
+	PerInterfaceNetwork bool `mapstructure:"per_interface_network"`
+	// SharedNetwork controls the network metrics of containers that share
+	// the network namespace of the host (network mode "host") or of another
+	// container (network mode "container:<id>"), whose stats are also
+	// reported by the host or that container: "report" reports them like
+	// other containers, "label" adds a network_mode label (host, container or
+	// private) to all network metrics so that shared stats can be excluded
+	// from sums, and "suppress" drops the network metrics of those
+	// containers. The network mode is read from the inspect API.
+	SharedNetwork string `mapstructure:"shared_network"`
+	// HostProcPath is the path where the host /proc filesystem is mounted,
+	// e.g. "/host/proc". When set, the number of open file descriptors and
+	// threads of the main process of each container is read from it.
+	HostProcPath string `mapstructure:"host_proc_path"`
+	// DiskUsage reports the size of the writable layer and root filesystem of
+	// each container, which the docker daemon computes by walking the
+	// container files, and the size of its log file. The log file is read at
+	// the path reported by docker, so the docker data root must be mounted at
+	// the same path.
+	DiskUsage bool `mapstructure:"disk_usage"`
+	// DaemonMetrics reports docker daemon metrics: number of images and
+	// dangling images, image, volume and build cache disk usage, and the
+	// storage driver and version of the daemon. The build cache size is only
+	// reported by daemons supporting API version 1.31 or later.
+	DaemonMetrics bool `mapstructure:"daemon_metrics"`
+	// DaemonScrapeInterval controls how often daemon metrics are scraped.
+	DaemonScrapeInterval time.Duration `mapstructure:"daemon_scrape_interval"`
+	// InspectCacheTTL is how long the container info read from the inspect
+	// API is reused, unless the container state or status changes or its
+	// status shows that it restarted. Health check failing streaks and
+	// container sizes may lag by up to the TTL. Zero, the default, disables
+	// the cache.
+	InspectCacheTTL time.Duration `mapstructure:"inspect_cache_ttl"`
+	// Include restricts scraping to the containers matching the filter. All
+	// containers are scraped if it is not set.
+	Include *ContainerFilter `mapstructure:"include"`
+	// Exclude skips the containers matching the filter. It is applied after
+	// Include.
+	Exclude *ContainerFilter `mapstructure:"exclude"`
+	// ContainerLabels lists docker container labels to attach to every metric
+	// of the container. The metric label key is the docker label key prefixed
+	// with "label_", with characters other than letters, digits and
+	// underscores replaced by underscores.
+	ContainerLabels []string `mapstructure:"container_labels"`
+	// ImageLabels attaches the image and image_id labels to every metric of
+	// the container.
+	ImageLabels bool `mapstructure:"image_labels"`
+	// ResourcePerContainer sends the metrics of each container in their own
+	// batch, with a "container" resource carrying the container.id,
+	// container.name and container.image.name labels instead of the
+	// container_name metric label. Daemon, event and scraper metrics are sent
+	// without resource.
+	ResourcePerContainer bool `mapstructure:"resource_per_container"`
+}
+
+// ContainerFilter selects containers. A container matches the filter if it
+// matches any of its criteria.
+type ContainerFilter struct {
+	// Names are regular expressions matched against the container name.
+	Names []string `mapstructure:"names"`
+	// Images are image names, as reported by the docker API (e.g.
+	// "gcr.io/google-appengine/fluentd-logger:latest"), matched exactly.
+	Images []string `mapstructure:"images"`
+	// Labels are docker labels in "key" or "key=value" form. A label without
+	// value matches any container that has that label.
+	Labels []string `mapstructure:"labels"`
+}
+
+// TLSConfig configures the TLS connection to the docker daemon.
+type TLSConfig struct {
+	// CAFile is the PEM file of the certificate authority used to verify the
+	// daemon certificate. The system pool is used if it is not set.
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile are the PEM files of the client certificate and
+	// key. Both must be set for the client to authenticate to the daemon.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// InsecureSkipVerify disables the verification of the daemon certificate.
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
 }
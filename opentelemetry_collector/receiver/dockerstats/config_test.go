@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/config"
@@ -34,5 +35,88 @@ func TestLoadConfig(t *testing.T) {
 			NameVal: "dockerstats/customname",
 		},
 		ScrapeInterval: 10 * time.Minute,
+		Endpoint:       "tcp://10.0.0.1:2376",
+		APIVersion:     "1.24",
+		TLS: &TLSConfig{
+			CAFile:   "/certs/ca.pem",
+			CertFile: "/certs/cert.pem",
+			KeyFile:  "/certs/key.pem",
+		},
+		Timeout:              30 * time.Second,
+		Mode:                 "stream",
+		CgroupRoot:           "/host/sys/fs/cgroup",
+		WatchEvents:          true,
+		MaxConcurrentScrapes: 8,
+		PerInterfaceNetwork:  true,
+		SharedNetwork:        "suppress",
+		HostProcPath:         "/host/proc",
+		DiskUsage:            true,
+		DaemonMetrics:        true,
+		DaemonScrapeInterval: time.Hour,
+		InspectCacheTTL:      time.Minute,
+		Include: &ContainerFilter{
+			Names:  []string{"^app$", "^nginx_proxy$"},
+			Labels: []string{"com.google.appengine.role"},
+		},
+		Exclude: &ContainerFilter{
+			Images: []string{"gcr.io/google-appengine/debugger:latest"},
+			Labels: []string{"com.google.appengine.role=debug"},
+		},
+		ContainerLabels:      []string{"com.google.appengine.role", "version"},
+		ImageLabels:          true,
+		ResourcePerContainer: true,
+	})
+}
+
+func TestNewContainerFilter(t *testing.T) {
+	f, err := newContainerFilter(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	f, err = newContainerFilter(&ContainerFilter{})
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	f, err = newContainerFilter(&ContainerFilter{
+		Names:  []string{"^app"},
+		Images: []string{"busybox:latest"},
+		Labels: []string{"role=web", "debug"},
 	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"role": "web", "debug": ""}, f.labels)
+
+	_, err = newContainerFilter(&ContainerFilter{Names: []string{"("}})
+	assert.Error(t, err)
+	_, err = newContainerFilter(&ContainerFilter{Images: []string{""}})
+	assert.Error(t, err)
+	_, err = newContainerFilter(&ContainerFilter{Labels: []string{"=value"}})
+	assert.Error(t, err)
+}
+
+func TestContainerFilterMatches(t *testing.T) {
+	f, err := newContainerFilter(&ContainerFilter{
+		Names:  []string{"^app$"},
+		Images: []string{"busybox:latest"},
+		Labels: []string{"role=web", "debug"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		container types.Container
+		want      bool
+	}{
+		{"name", types.Container{Names: []string{"/app"}}, true},
+		{"name regex is anchored", types.Container{Names: []string{"/app2"}}, false},
+		{"image", types.Container{Names: []string{"/x"}, Image: "busybox:latest"}, true},
+		{"other image", types.Container{Names: []string{"/x"}, Image: "busybox:1.0"}, false},
+		{"label value", types.Container{Names: []string{"/x"}, Labels: map[string]string{"role": "web"}}, true},
+		{"other label value", types.Container{Names: []string{"/x"}, Labels: map[string]string{"role": "db"}}, false},
+		{"label without value", types.Container{Names: []string{"/x"}, Labels: map[string]string{"debug": "true"}}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, f.matches(&tc.container, containerName(&tc.container)))
+		})
+	}
 }
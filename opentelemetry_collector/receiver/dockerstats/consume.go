@@ -0,0 +1,43 @@
+package dockerstats
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxConsumeAttempts is the number of times a batch is sent to the
+	// consumer before it is dropped.
+	maxConsumeAttempts = 3
+	// consumeRetryBackoff is the delay before the first retry. It doubles on
+	// each retry.
+	consumeRetryBackoff = 100 * time.Millisecond
+)
+
+// consumeWithRetry sends md to the consumer, retrying on errors up to
+// maxConsumeAttempts times as long as ctx is not done. It returns the last
+// consumer error if the batch could not be delivered.
+func consumeWithRetry(ctx context.Context, c consumer.MetricsConsumer, md pdata.Metrics, logger *zap.Logger) error {
+	backoff := consumeRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.ConsumeMetrics(ctx, md)
+		if err == nil {
+			return nil
+		}
+		if attempt == maxConsumeAttempts {
+			return err
+		}
+		logger.Debug("Failed to consume docker metrics, retrying",
+			zap.Int("attempt", attempt), zap.Duration("backoff", backoff), zap.Error(err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
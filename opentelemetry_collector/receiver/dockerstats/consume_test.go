@@ -0,0 +1,82 @@
+package dockerstats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"go.opentelemetry.io/collector/consumer/pdata"
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+)
+
+// failingMetricsConsumer fails the first failures calls, then keeps the
+// metrics like fakeMetricsConsumer.
+type failingMetricsConsumer struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	metrics  pdata.Metrics
+}
+
+func (c *failingMetricsConsumer) ConsumeMetrics(ctx context.Context, md pdata.Metrics) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.calls <= c.failures {
+		return fmt.Errorf("exporter queue is full")
+	}
+	c.metrics = md
+	return nil
+}
+
+func TestConsumeWithRetry(t *testing.T) {
+	c := &failingMetricsConsumer{failures: 2}
+	err := consumeWithRetry(context.Background(), c, pdata.Metrics{}, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, c.calls)
+}
+
+func TestConsumeWithRetryGivesUp(t *testing.T) {
+	c := &failingMetricsConsumer{failures: 10}
+	err := consumeWithRetry(context.Background(), c, pdata.Metrics{}, zap.NewNop())
+	assert.Error(t, err)
+	assert.Equal(t, maxConsumeAttempts, c.calls)
+}
+
+func TestConsumeWithRetryStopsAtDeadline(t *testing.T) {
+	c := &failingMetricsConsumer{failures: 10}
+	ctx, cancel := context.WithTimeout(context.Background(), consumeRetryBackoff/2)
+	defer cancel()
+	err := consumeWithRetry(ctx, c, pdata.Metrics{}, zap.NewNop())
+	assert.Error(t, err)
+	assert.Equal(t, 1, c.calls)
+}
+
+func TestScraperExportDroppedBatches(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	c := &failingMetricsConsumer{failures: 2 * maxConsumeAttempts}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &fakeDocker{},
+		scrapeInterval: 10 * time.Second,
+		logger:         zap.New(core),
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+	s.export(context.Background())
+	assert.Equal(t, 2, logs.FilterMessage("Dropped docker metrics batch").Len())
+
+	s.export(context.Background())
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyTimeSeriesValue(t, data, "dockerstats/consumer/dropped_batches", nil, 2)
+	verifyContainerMetricValue(t, data, "container/memory/usage", "name1a", 33)
+}
@@ -0,0 +1,278 @@
+package dockerstats
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mpb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+	"github.com/golang/glog"
+	"github.com/googlecloudplatform/appengine-sidecars-docker/opentelemetry_collector/receiver/metricgenerator"
+
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumerdata"
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+	"go.uber.org/zap"
+)
+
+var (
+	storageDriverLabel = &mpb.LabelKey{
+		Key:         "storage_driver",
+		Description: "Storage driver of the docker daemon",
+	}
+	versionLabel = &mpb.LabelKey{
+		Key:         "version",
+		Description: "Version of the docker daemon",
+	}
+
+	daemonImagesDesc = &mpb.MetricDescriptor{
+		Name:        "docker/images",
+		Description: "Number of images, intermediate layers excluded.",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+	}
+	daemonDanglingImagesDesc = &mpb.MetricDescriptor{
+		Name:        "docker/images/dangling",
+		Description: "Number of untagged images that are not the parent of another image.",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+	}
+	daemonImagesSizeDesc = &mpb.MetricDescriptor{
+		Name:        "docker/images/size_bytes",
+		Description: "Disk space used by the image layers, shared layers counted once.",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+	}
+	daemonVolumesSizeDesc = &mpb.MetricDescriptor{
+		Name:        "docker/volumes/size_bytes",
+		Description: "Disk space used by the local volumes.",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+	}
+	daemonBuildCacheSizeDesc = &mpb.MetricDescriptor{
+		Name:        "docker/build_cache/size_bytes",
+		Description: "Disk space used by the build cache.",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+	}
+	daemonInfoDesc = &mpb.MetricDescriptor{
+		Name:        "docker/info",
+		Description: "Docker daemon information, in labels. The value is always 1.",
+		Unit:        "1",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{storageDriverLabel, versionLabel},
+	}
+)
+
+// buildCacheMinVersion is the first API version whose disk usage response
+// has the build cache size.
+const buildCacheMinVersion = "1.31"
+
+// diskUsage is the response of the disk usage API, with the build cache
+// fields that the vendored docker types predate.
+type diskUsage struct {
+	types.DiskUsage
+	// BuilderSize is reported by API versions 1.31 to 1.41.
+	BuilderSize *int64
+	// BuildCache is reported from API version 1.39.
+	BuildCache []struct {
+		Size   int64
+		Shared bool
+	}
+}
+
+// buildCacheSize returns the size of the build cache, and false if the
+// response does not have it. Records shared with images are not counted, as
+// their size is part of the image layers.
+func (du *diskUsage) buildCacheSize() (int64, bool) {
+	if du.BuilderSize != nil {
+		return *du.BuilderSize, true
+	}
+	if du.BuildCache == nil {
+		return 0, false
+	}
+	var size int64
+	for _, r := range du.BuildCache {
+		if !r.Shared {
+			size += r.Size
+		}
+	}
+	return size, true
+}
+
+// daemonAPIClient is the part of the docker API used for daemon metrics.
+type daemonAPIClient interface {
+	client.ImageAPIClient
+	client.SystemAPIClient
+	ClientVersion() string
+	// diskUsage reads the disk usage with the given API version.
+	diskUsage(ctx context.Context, version string) (*diskUsage, error)
+}
+
+// daemonClient is the docker client used for daemon metrics. The disk usage
+// is read with a raw API request, as the vendored client does not decode the
+// build cache size.
+type daemonClient struct {
+	*client.Client
+	endpoint *dockerEndpoint
+}
+
+func (c *daemonClient) diskUsage(ctx context.Context, version string) (*diskUsage, error) {
+	var du diskUsage
+	if err := c.endpoint.get(ctx, version, "/system/df", &du); err != nil {
+		return nil, err
+	}
+	return &du, nil
+}
+
+// daemonScraper periodically exports metrics about the docker daemon itself:
+// its images, disk usage and version. The disk usage API walks the image and
+// volume directories, so it is scraped on its own, usually longer, interval.
+type daemonScraper struct {
+	startTime      time.Time
+	scrapeInterval time.Duration
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+	metricConsumer consumer.MetricsConsumer
+	docker         daemonAPIClient
+	logger         *zap.Logger
+	now            func() time.Time
+}
+
+func newDaemonScraper(scrapeInterval time.Duration, docker daemonAPIClient, metricConsumer consumer.MetricsConsumer, logger *zap.Logger) *daemonScraper {
+	return &daemonScraper{
+		scrapeInterval: scrapeInterval,
+		metricConsumer: metricConsumer,
+		docker:         docker,
+		logger:         logger,
+		now:            time.Now,
+	}
+}
+
+func (d *daemonScraper) start() {
+	d.startTime = d.now()
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.scrapeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.export(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stop cancels the scrape loop and waits for it to return or for ctx to be
+// done. It is safe to call without start.
+func (d *daemonScraper) stop(ctx context.Context) error {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	return waitContext(ctx, &d.wg)
+}
+
+func (d *daemonScraper) export(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, d.scrapeInterval)
+	defer cancel()
+
+	metrics, err := d.scrape(ctx)
+	if err != nil {
+		glog.Warningf("Failed to scrape docker daemon metrics: %v", err)
+	}
+	if len(metrics) == 0 {
+		return
+	}
+	md := consumerdata.MetricsData{Metrics: metrics}
+	if err := consumeWithRetry(ctx, d.metricConsumer, pdatautil.MetricsFromMetricsData([]consumerdata.MetricsData{md}), d.logger); err != nil {
+		d.logger.Warn("Dropped docker daemon metrics batch", zap.Error(err))
+	}
+}
+
+// scrape reads the daemon metrics. The metrics of the calls that succeeded
+// are returned along with the first error.
+func (d *daemonScraper) scrape(ctx context.Context) ([]*mpb.Metric, error) {
+	var metrics []*mpb.Metric
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if info, err := d.docker.Info(ctx); err != nil {
+		fail(fmt.Errorf("failed to retrieve daemon info: %v", err))
+	} else {
+		labelValues := []*mpb.LabelValue{
+			metricgenerator.MakeLabelValue(info.Driver),
+			metricgenerator.MakeLabelValue(info.ServerVersion),
+		}
+		metrics = append(metrics, d.makeInt64Metric(daemonInfoDesc, 1, labelValues))
+	}
+
+	version := d.diskUsageVersion(ctx)
+	if du, err := d.docker.diskUsage(ctx, version); err != nil {
+		fail(fmt.Errorf("failed to retrieve disk usage: %v", err))
+	} else {
+		var volumesSize int64
+		for _, v := range du.Volumes {
+			// The size is -1 when it could not be computed.
+			if v.UsageData != nil && v.UsageData.Size > 0 {
+				volumesSize += v.UsageData.Size
+			}
+		}
+		metrics = append(metrics,
+			d.makeInt64Metric(daemonImagesDesc, int64(len(du.Images)), nil),
+			d.makeInt64Metric(daemonImagesSizeDesc, du.LayersSize, nil),
+			d.makeInt64Metric(daemonVolumesSizeDesc, volumesSize, nil))
+		if size, ok := du.buildCacheSize(); ok && !versions.LessThan(version, buildCacheMinVersion) {
+			metrics = append(metrics, d.makeInt64Metric(daemonBuildCacheSizeDesc, size, nil))
+		}
+	}
+
+	args := filters.NewArgs()
+	args.Add("dangling", "true")
+	dangling, err := d.docker.ImageList(ctx, types.ImageListOptions{Filters: args})
+	if err != nil {
+		fail(fmt.Errorf("failed to list dangling images: %v", err))
+	} else {
+		metrics = append(metrics, d.makeInt64Metric(daemonDanglingImagesDesc, int64(len(dangling)), nil))
+	}
+	return metrics, firstErr
+}
+
+// diskUsageVersion returns the API version of the disk usage request: the
+// version of the client, raised to buildCacheMinVersion if the daemon
+// supports it so that the build cache size is reported.
+func (d *daemonScraper) diskUsageVersion(ctx context.Context) string {
+	version := strings.TrimPrefix(d.docker.ClientVersion(), "v")
+	if !versions.LessThan(version, buildCacheMinVersion) {
+		return version
+	}
+	ping, err := d.docker.Ping(ctx)
+	if err != nil || versions.LessThan(ping.APIVersion, buildCacheMinVersion) {
+		return version
+	}
+	return buildCacheMinVersion
+}
+
+func (d *daemonScraper) makeInt64Metric(desc *mpb.MetricDescriptor, val int64, labelValues []*mpb.LabelValue) *mpb.Metric {
+	return &mpb.Metric{
+		MetricDescriptor: desc,
+		Timeseries: []*mpb.TimeSeries{
+			metricgenerator.MakeInt64TimeSeries(val, d.startTime, d.now(), labelValues),
+		},
+	}
+}
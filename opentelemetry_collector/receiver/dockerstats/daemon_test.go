@@ -0,0 +1,142 @@
+package dockerstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"go.opentelemetry.io/collector/consumer/consumerdata"
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+)
+
+// fakeDaemonDocker is a daemon serving API version apiVersion to a client
+// requesting version 1.25 by default.
+type fakeDaemonDocker struct {
+	client.Client
+
+	apiVersion   string
+	diskUsageErr error
+	// dfVersions are the API versions of the disk usage requests.
+	dfVersions []string
+}
+
+func (d *fakeDaemonDocker) Info(ctx context.Context) (types.Info, error) {
+	return types.Info{Driver: "overlay2", ServerVersion: "19.03.8"}, nil
+}
+
+func (d *fakeDaemonDocker) ClientVersion() string {
+	return "1.25"
+}
+
+func (d *fakeDaemonDocker) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{APIVersion: d.apiVersion}, nil
+}
+
+func (d *fakeDaemonDocker) diskUsage(ctx context.Context, version string) (*diskUsage, error) {
+	d.dfVersions = append(d.dfVersions, version)
+	if d.diskUsageErr != nil {
+		return nil, d.diskUsageErr
+	}
+	du := &diskUsage{
+		DiskUsage: types.DiskUsage{
+			LayersSize: 1 << 30,
+			Images:     []*types.ImageSummary{{ID: "sha256:aaa"}, {ID: "sha256:bbb"}, {ID: "sha256:ccc"}},
+			Volumes: []*types.Volume{
+				{Name: "data", UsageData: &types.VolumeUsageData{Size: 2048}},
+				{Name: "logs", UsageData: &types.VolumeUsageData{Size: 1024}},
+				{Name: "remote", UsageData: &types.VolumeUsageData{Size: -1}},
+				{Name: "unknown"},
+			},
+		},
+	}
+	if version == buildCacheMinVersion {
+		size := int64(4096)
+		du.BuilderSize = &size
+	}
+	return du, nil
+}
+
+func (d *fakeDaemonDocker) ImageList(ctx context.Context, opts types.ImageListOptions) ([]types.ImageSummary, error) {
+	if opts.Filters.ExactMatch("dangling", "true") {
+		return []types.ImageSummary{{ID: "sha256:ccc"}}, nil
+	}
+	return nil, fmt.Errorf("unexpected filters")
+}
+
+func TestDaemonScraperExport(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	docker := &fakeDaemonDocker{apiVersion: "1.40"}
+	d := newDaemonScraper(time.Minute, docker, c, zap.NewNop())
+	d.now = fakeNow
+	d.startTime = fakeNow()
+
+	d.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyTimeSeriesValue(t, data, "docker/images", nil, 3)
+	verifyTimeSeriesValue(t, data, "docker/images/dangling", nil, 1)
+	verifyTimeSeriesValue(t, data, "docker/images/size_bytes", nil, 1<<30)
+	verifyTimeSeriesValue(t, data, "docker/volumes/size_bytes", nil, 3072)
+	verifyTimeSeriesValue(t, data, "docker/info", []string{"overlay2", "19.03.8"}, 1)
+	verifyTimeSeriesValue(t, data, "docker/build_cache/size_bytes", nil, 4096)
+	assert.Equal(t, []string{"1.31"}, docker.dfVersions)
+}
+
+func TestDaemonScraperOldDaemonHasNoBuildCache(t *testing.T) {
+	docker := &fakeDaemonDocker{apiVersion: "1.30"}
+	d := newDaemonScraper(time.Minute, docker, nil, zap.NewNop())
+	d.now = fakeNow
+
+	metrics, err := d.scrape(context.Background())
+	require.NoError(t, err)
+	data := consumerdata.MetricsData{Metrics: metrics}
+	verifyTimeSeriesValue(t, data, "docker/images/size_bytes", nil, 1<<30)
+	verifyTimeSeriesAbsent(t, data, "docker/build_cache/size_bytes", nil)
+	assert.Equal(t, []string{"1.25"}, docker.dfVersions)
+}
+
+func TestBuildCacheSize(t *testing.T) {
+	size := int64(100)
+	du := &diskUsage{BuilderSize: &size}
+	got, ok := du.buildCacheSize()
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), got)
+
+	// API 1.42 and later only list the build cache records.
+	du = &diskUsage{}
+	require.NoError(t, json.Unmarshal([]byte(`{"BuildCache":[{"Size":10},{"Size":20,"Shared":true},{"Size":30}]}`), du))
+	got, ok = du.buildCacheSize()
+	assert.True(t, ok)
+	assert.Equal(t, int64(40), got)
+
+	_, ok = (&diskUsage{}).buildCacheSize()
+	assert.False(t, ok)
+}
+
+func TestDaemonScraperPartialFailure(t *testing.T) {
+	d := newDaemonScraper(time.Minute, &fakeDaemonDocker{diskUsageErr: fmt.Errorf("daemon busy")}, nil, zap.NewNop())
+	d.now = fakeNow
+
+	metrics, err := d.scrape(context.Background())
+	assert.Error(t, err)
+	data := consumerdata.MetricsData{Metrics: metrics}
+	verifyTimeSeriesValue(t, data, "docker/info", []string{"overlay2", "19.03.8"}, 1)
+	verifyTimeSeriesValue(t, data, "docker/images/dangling", nil, 1)
+	verifyTimeSeriesAbsent(t, data, "docker/images", nil)
+}
+
+func TestDaemonScraperStartStop(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	d := newDaemonScraper(time.Hour, &fakeDaemonDocker{}, c, zap.NewNop())
+	d.start()
+	d.stop(context.Background())
+	require.Equal(t, fakeMetricsConsumer{}, *c)
+}
@@ -0,0 +1,146 @@
+package dockerstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/sockets"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+var apiVersionRegexp = regexp.MustCompile(`^v?[0-9]+\.[0-9]+$`)
+
+// validateClientConfig checks the docker client options of the config.
+func validateClientConfig(c *Config) error {
+	if c.Endpoint != "" {
+		if _, _, _, err := client.ParseHost(c.Endpoint); err != nil {
+			return fmt.Errorf("invalid endpoint: %q: %v", c.Endpoint, err)
+		}
+	}
+	if c.APIVersion != "" && !apiVersionRegexp.MatchString(c.APIVersion) {
+		return fmt.Errorf("invalid api_version: %q, must be of the form 1.25", c.APIVersion)
+	}
+	if c.TLS != nil && (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("invalid tls: cert_file and key_file must be set together")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("invalid timeout: %v, must not be negative", c.Timeout)
+	}
+	return nil
+}
+
+// dockerEndpoint is the address of the docker daemon, the API version
+// requested and the HTTP client used to reach it. It is shared by the docker
+// API client and the raw API requests.
+type dockerEndpoint struct {
+	host       string
+	version    string
+	httpClient *http.Client
+}
+
+// newDockerEndpoint creates the docker endpoint from the config, or from the
+// environment if no client option is set, the same way as
+// client.NewEnvClient.
+func newDockerEndpoint(c *Config) (*dockerEndpoint, error) {
+	host, version := c.Endpoint, c.APIVersion
+	var tlsOptions *tlsconfig.Options
+	if c.TLS != nil {
+		tlsOptions = &tlsconfig.Options{
+			CAFile:             c.TLS.CAFile,
+			CertFile:           c.TLS.CertFile,
+			KeyFile:            c.TLS.KeyFile,
+			InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+		}
+	}
+	if c.Endpoint == "" && c.APIVersion == "" && c.TLS == nil {
+		version = os.Getenv("DOCKER_API_VERSION")
+		if certPath := os.Getenv("DOCKER_CERT_PATH"); certPath != "" {
+			tlsOptions = &tlsconfig.Options{
+				CAFile:             filepath.Join(certPath, "ca.pem"),
+				CertFile:           filepath.Join(certPath, "cert.pem"),
+				KeyFile:            filepath.Join(certPath, "key.pem"),
+				InsecureSkipVerify: os.Getenv("DOCKER_TLS_VERIFY") == "",
+			}
+		}
+	}
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = client.DefaultDockerHost
+	}
+	if version == "" {
+		version = client.DefaultVersion
+	}
+
+	proto, addr, _, err := client.ParseHost(host)
+	if err != nil {
+		return nil, err
+	}
+	transport := new(http.Transport)
+	if err := sockets.ConfigureTransport(transport, proto, addr); err != nil {
+		return nil, err
+	}
+	if tlsOptions != nil {
+		tlsc, err := tlsconfig.Client(*tlsOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS config: %v", err)
+		}
+		transport.TLSClientConfig = tlsc
+	}
+	return &dockerEndpoint{
+		host:       host,
+		version:    strings.TrimPrefix(version, "v"),
+		httpClient: &http.Client{Transport: transport},
+	}, nil
+}
+
+// newClient creates a docker API client for the endpoint.
+func (e *dockerEndpoint) newClient() (*client.Client, error) {
+	return client.NewClient(e.host, e.version, e.httpClient, nil)
+}
+
+// get requests the API path p, e.g. "/system/df", with the given API version
+// and decodes the JSON response into v. It is used for the responses that
+// the vendored client does not fully decode.
+func (e *dockerEndpoint) get(ctx context.Context, version, p string, v interface{}) error {
+	proto, addr, basePath, err := client.ParseHost(e.host)
+	if err != nil {
+		return err
+	}
+	u := url.URL{Scheme: "http", Host: addr, Path: fmt.Sprintf("%s/v%s%s", basePath, version, p)}
+	if t, ok := e.httpClient.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		u.Scheme = "https"
+	}
+	if proto == "unix" || proto == "npipe" {
+		// The socket is dialed by the transport, the host is only a
+		// placeholder.
+		u.Host = "docker"
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("request %s failed with status %d: %s", p, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s response: %v", p, err)
+	}
+	return nil
+}
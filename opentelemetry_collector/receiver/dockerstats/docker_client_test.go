@@ -0,0 +1,140 @@
+package dockerstats
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDaemon serves the container list and disk usage endpoints and records
+// the request paths.
+type fakeDaemon struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	d.paths = append(d.paths, r.URL.Path)
+	d.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasSuffix(r.URL.Path, "/system/df") {
+		w.Write([]byte(`{"LayersSize":1024,"Images":[],"Volumes":[],"BuilderSize":512}`))
+		return
+	}
+	json.NewEncoder(w).Encode([]types.Container{{ID: "id1", Names: []string{"/app"}}})
+}
+
+// newTestDockerClient creates the docker API client of the config.
+func newTestDockerClient(c *Config) (*client.Client, error) {
+	e, err := newDockerEndpoint(c)
+	if err != nil {
+		return nil, err
+	}
+	return e.newClient()
+}
+
+func TestNewDockerClientUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerstats")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "docker.sock")
+
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	d := &fakeDaemon{}
+	srv := &httptest.Server{Listener: l, Config: &http.Server{Handler: d}}
+	srv.Start()
+	defer srv.Close()
+
+	c, err := newTestDockerClient(&Config{Endpoint: "unix://" + socket, APIVersion: "1.24"})
+	require.NoError(t, err)
+	containers, err := c.ContainerList(context.Background(), types.ContainerListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/app"}, containers[0].Names)
+	assert.Equal(t, []string{"/v1.24/containers/json"}, d.paths)
+}
+
+func TestDaemonClientDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerstats")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "docker.sock")
+
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	d := &fakeDaemon{}
+	srv := &httptest.Server{Listener: l, Config: &http.Server{Handler: d}}
+	srv.Start()
+	defer srv.Close()
+
+	e, err := newDockerEndpoint(&Config{Endpoint: "unix://" + socket})
+	require.NoError(t, err)
+	c, err := e.newClient()
+	require.NoError(t, err)
+	du, err := (&daemonClient{Client: c, endpoint: e}).diskUsage(context.Background(), "1.31")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), du.LayersSize)
+	size, ok := du.buildCacheSize()
+	assert.True(t, ok)
+	assert.Equal(t, int64(512), size)
+	assert.Equal(t, []string{"/v1.31/system/df"}, d.paths)
+}
+
+func TestNewDockerClientTLS(t *testing.T) {
+	d := &fakeDaemon{}
+	srv := httptest.NewTLSServer(d)
+	defer srv.Close()
+
+	f, err := ioutil.TempFile("", "ca.pem")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	require.NoError(t, pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	require.NoError(t, f.Close())
+
+	endpoint := "tcp://" + srv.Listener.Addr().String()
+	c, err := newTestDockerClient(&Config{Endpoint: endpoint, TLS: &TLSConfig{CAFile: f.Name()}})
+	require.NoError(t, err)
+	_, err = c.ContainerList(context.Background(), types.ContainerListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/v1.25/containers/json"}, d.paths)
+
+	// The daemon certificate is not trusted without the CA.
+	c, err = newTestDockerClient(&Config{Endpoint: endpoint, TLS: &TLSConfig{}})
+	require.NoError(t, err)
+	_, err = c.ContainerList(context.Background(), types.ContainerListOptions{})
+	assert.Error(t, err)
+
+	_, err = newTestDockerClient(&Config{Endpoint: endpoint, TLS: &TLSConfig{CAFile: "testdata/missing.pem"}})
+	assert.Error(t, err)
+}
+
+func TestValidateClientConfig(t *testing.T) {
+	assert.NoError(t, validateClientConfig(&Config{}))
+	assert.NoError(t, validateClientConfig(&Config{
+		Endpoint:   "tcp://10.0.0.1:2376",
+		APIVersion: "v1.25",
+		TLS:        &TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"},
+		Timeout:    time.Second,
+	}))
+
+	assert.Error(t, validateClientConfig(&Config{Endpoint: "10.0.0.1:2376"}))
+	assert.Error(t, validateClientConfig(&Config{APIVersion: "latest"}))
+	assert.Error(t, validateClientConfig(&Config{TLS: &TLSConfig{CertFile: "cert.pem"}}))
+	assert.Error(t, validateClientConfig(&Config{Timeout: -time.Second}))
+}
@@ -0,0 +1,300 @@
+package dockerstats
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/golang/glog"
+
+	mpb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+
+	"github.com/googlecloudplatform/appengine-sidecars-docker/opentelemetry_collector/receiver/metricgenerator"
+)
+
+var (
+	eventLabel = &mpb.LabelKey{
+		Key:         "event",
+		Description: "Container lifecycle event (start, die, oom, kill, health_status:<status>)",
+	}
+
+	eventCountDesc = &mpb.MetricDescriptor{
+		Name:        "container/events",
+		Description: "Number of lifecycle events of the container reported by the docker daemon",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel, eventLabel},
+	}
+)
+
+// watchedEvents are the container event actions counted by the watcher.
+var watchedEvents = []string{"start", "die", "oom", "kill", "health_status"}
+
+const (
+	minEventsBackoff = 1 * time.Second
+	maxEventsBackoff = 1 * time.Minute
+	// eventsRetention is how long the event counts of a container are kept
+	// after it was last listed or had an event.
+	eventsRetention = 1 * time.Hour
+)
+
+type eventKey struct {
+	container string
+	event     string
+}
+
+// eventSeries tracks the event counts of a container.
+type eventSeries struct {
+	// start is the start time of the cumulative counts of the container.
+	start    time.Time
+	lastSeen time.Time
+}
+
+// eventsWatcher subscribes to the docker events API and counts the lifecycle
+// events of the containers. It reconnects with exponential backoff when the
+// event stream fails, e.g. because the docker daemon restarted.
+type eventsWatcher struct {
+	docker client.SystemAPIClient
+	// accept returns whether the events of the container are counted.
+	accept func(c *types.Container) bool
+
+	startTime  time.Time
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	mu     sync.Mutex
+	counts map[eventKey]int64
+	// series has an entry for every container in counts.
+	series map[string]*eventSeries
+	// lastEvent is the time of the last counted event, in nanoseconds. It is
+	// used to skip the events replayed after a reconnection.
+	lastEvent int64
+
+	now func() time.Time
+}
+
+func newEventsWatcher(docker client.SystemAPIClient, accept func(c *types.Container) bool) *eventsWatcher {
+	return &eventsWatcher{
+		docker:     docker,
+		accept:     accept,
+		minBackoff: minEventsBackoff,
+		maxBackoff: maxEventsBackoff,
+		counts:     make(map[eventKey]int64),
+		series:     make(map[string]*eventSeries),
+		now:        time.Now,
+	}
+}
+
+func (w *eventsWatcher) start() {
+	w.startTime = w.now()
+	w.lastEvent = w.startTime.UnixNano()
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		w.run(ctx)
+	}()
+}
+
+// stop cancels the event stream and waits for the watcher to return or for
+// ctx to be done. It is safe to call without start.
+func (w *eventsWatcher) stop(ctx context.Context) error {
+	if w.cancel == nil {
+		return nil
+	}
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run reads the event stream until ctx is cancelled, reconnecting on errors.
+func (w *eventsWatcher) run(ctx context.Context) {
+	backoff := w.minBackoff
+	for {
+		received, err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = w.minBackoff
+		}
+		glog.Warningf("Docker event stream failed, reconnecting in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// watch subscribes to the container events that happened since the last
+// counted event and handles them until the stream fails. It returns whether
+// any event was received.
+func (w *eventsWatcher) watch(ctx context.Context) (bool, error) {
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	for _, e := range watchedEvents {
+		args.Add("event", e)
+	}
+	w.mu.Lock()
+	since := time.Unix(0, w.lastEvent)
+	w.mu.Unlock()
+	opts := types.EventsOptions{
+		Since:   eventsTimestamp(since),
+		Filters: args,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs, errs := w.docker.Events(ctx, opts)
+	received := false
+	for {
+		select {
+		case msg := <-msgs:
+			received = true
+			w.handle(msg)
+		case err := <-errs:
+			return received, err
+		case <-ctx.Done():
+			return received, ctx.Err()
+		}
+	}
+}
+
+// eventsTimestamp formats t the way the docker events API expects it: unix
+// seconds and nanoseconds, e.g. 1577836800.000000001.
+func eventsTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// handle counts a container event.
+func (w *eventsWatcher) handle(msg events.Message) {
+	if msg.Type != events.ContainerEventType {
+		return
+	}
+	event := msg.Action
+	if strings.HasPrefix(event, "health_status") {
+		// Health status events have the form "health_status: healthy".
+		event = strings.Replace(event, " ", "", -1)
+	} else if !isWatchedEvent(event) {
+		return
+	}
+
+	name := msg.Actor.Attributes["name"]
+	if name == "" {
+		name = msg.Actor.ID
+	}
+	c := &types.Container{
+		ID:     msg.Actor.ID,
+		Names:  []string{"/" + name},
+		Image:  msg.Actor.Attributes["image"],
+		Labels: msg.Actor.Attributes,
+	}
+	if w.accept != nil && !w.accept(c) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if msg.TimeNano != 0 {
+		if msg.TimeNano <= w.lastEvent {
+			return
+		}
+		w.lastEvent = msg.TimeNano
+	}
+	now := w.now()
+	if sr, ok := w.series[name]; ok {
+		sr.lastSeen = now
+	} else {
+		w.series[name] = &eventSeries{start: now, lastSeen: now}
+	}
+	w.counts[eventKey{container: name, event: event}]++
+}
+
+// seen records that the containers with the given names are still listed,
+// so that their event counts are kept.
+func (w *eventsWatcher) seen(names []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	for _, name := range names {
+		if sr, ok := w.series[name]; ok {
+			sr.lastSeen = now
+		}
+	}
+}
+
+// prune removes the event counts of the containers that have not been seen
+// for eventsRetention. Their counts restart from zero, with a new start time,
+// if they have events again. It must be called with mu held.
+func (w *eventsWatcher) prune(now time.Time) {
+	for name, sr := range w.series {
+		if now.Sub(sr.lastSeen) <= eventsRetention {
+			continue
+		}
+		delete(w.series, name)
+		for k := range w.counts {
+			if k.container == name {
+				delete(w.counts, k)
+			}
+		}
+	}
+}
+
+func isWatchedEvent(action string) bool {
+	for _, e := range watchedEvents {
+		if action == e {
+			return true
+		}
+	}
+	return false
+}
+
+// metrics returns the event counts as a cumulative metric, or nil if no event
+// was counted yet. The counts of the containers not seen for eventsRetention
+// are dropped.
+func (w *eventsWatcher) metrics() []*mpb.Metric {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	w.prune(now)
+	if len(w.counts) == 0 {
+		return nil
+	}
+
+	keys := make([]eventKey, 0, len(w.counts))
+	for k := range w.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].container != keys[j].container {
+			return keys[i].container < keys[j].container
+		}
+		return keys[i].event < keys[j].event
+	})
+
+	timeseries := make([]*mpb.TimeSeries, 0, len(keys))
+	for _, k := range keys {
+		labelValues := []*mpb.LabelValue{metricgenerator.MakeLabelValue(k.container), metricgenerator.MakeLabelValue(k.event)}
+		timeseries = append(timeseries, metricgenerator.MakeInt64TimeSeries(w.counts[k], w.series[k.container].start, now, labelValues))
+	}
+	return []*mpb.Metric{{MetricDescriptor: eventCountDesc, Timeseries: timeseries}}
+}
@@ -0,0 +1,179 @@
+package dockerstats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/consumer/consumerdata"
+)
+
+// fakeEventsDocker serves one event stream per Events call. The first
+// failures calls fail immediately, as if the daemon was down.
+type fakeEventsDocker struct {
+	client.Client
+
+	mu       sync.Mutex
+	failures int
+	calls    int
+	opts     []types.EventsOptions
+	msgs     chan events.Message
+	errs     chan error
+}
+
+func (d *fakeEventsDocker) Events(ctx context.Context, opts types.EventsOptions) (<-chan events.Message, <-chan error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls++
+	d.opts = append(d.opts, opts)
+	d.msgs = make(chan events.Message)
+	d.errs = make(chan error, 1)
+	if d.calls <= d.failures {
+		d.errs <- fmt.Errorf("daemon unavailable")
+	}
+	return d.msgs, d.errs
+}
+
+func (d *fakeEventsDocker) send(t *testing.T, msg events.Message) {
+	var msgs chan events.Message
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		msgs = d.msgs
+		return d.calls > d.failures
+	}, time.Second, time.Millisecond)
+	msgs <- msg
+}
+
+func (d *fakeEventsDocker) numCalls() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.calls
+}
+
+func containerEvent(name, action string, timeNano int64) events.Message {
+	return events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor: events.Actor{
+			ID:         "id-" + name,
+			Attributes: map[string]string{"name": name, "image": "img"},
+		},
+		TimeNano: timeNano,
+	}
+}
+
+func newTestEventsWatcher(d client.SystemAPIClient) *eventsWatcher {
+	w := newEventsWatcher(d, nil)
+	w.minBackoff = time.Millisecond
+	w.maxBackoff = 4 * time.Millisecond
+	w.now = fakeNow
+	return w
+}
+
+func TestEventsWatcherHandle(t *testing.T) {
+	w := newTestEventsWatcher(nil)
+	w.accept = func(c *types.Container) bool { return containerName(c) != "ignored" }
+	w.lastEvent = 0
+
+	w.handle(containerEvent("app", "start", 1))
+	w.handle(containerEvent("app", "die", 2))
+	w.handle(containerEvent("app", "start", 3))
+	w.handle(containerEvent("app", "health_status: unhealthy", 4))
+	w.handle(containerEvent("app", "exec_start: ls", 5))
+	w.handle(containerEvent("ignored", "start", 6))
+	// Replayed event.
+	w.handle(containerEvent("app", "start", 3))
+	w.handle(events.Message{Type: events.ImageEventType, Action: "pull", TimeNano: 7})
+
+	data := consumerdata.MetricsData{Metrics: w.metrics()}
+	verifyTimeSeriesValue(t, data, "container/events", []string{"app", "start"}, 2)
+	verifyTimeSeriesValue(t, data, "container/events", []string{"app", "die"}, 1)
+	verifyTimeSeriesValue(t, data, "container/events", []string{"app", "health_status:unhealthy"}, 1)
+	verifyTimeSeriesAbsent(t, data, "container/events", []string{"app", "exec_start:ls"})
+	verifyTimeSeriesAbsent(t, data, "container/events", []string{"ignored", "start"})
+}
+
+func TestEventsWatcherPrunesContainers(t *testing.T) {
+	w := newTestEventsWatcher(nil)
+	now := fakeNow()
+	w.now = func() time.Time { return now }
+	w.lastEvent = 0
+
+	w.handle(containerEvent("app", "start", 1))
+	w.handle(containerEvent("build", "start", 2))
+	w.handle(containerEvent("build", "die", 3))
+
+	// app is still listed, build was removed.
+	now = now.Add(eventsRetention / 2)
+	w.seen([]string{"app"})
+	now = now.Add(eventsRetention/2 + time.Second)
+	data := consumerdata.MetricsData{Metrics: w.metrics()}
+	verifyTimeSeriesValue(t, data, "container/events", []string{"app", "start"}, 1)
+	verifyTimeSeriesAbsent(t, data, "container/events", []string{"build", "start"})
+	verifyTimeSeriesAbsent(t, data, "container/events", []string{"build", "die"})
+	assert.Len(t, w.counts, 1)
+	assert.Len(t, w.series, 1)
+
+	// A new container with the same name starts new series.
+	w.handle(containerEvent("build", "start", 4))
+	data = consumerdata.MetricsData{Metrics: w.metrics()}
+	ts := findTimeSeries(data, "container/events", []string{"build", "start"})
+	require.NotNil(t, ts)
+	assert.Equal(t, int64(1), ts.Points[0].GetInt64Value())
+	assert.Equal(t, now.Unix(), ts.StartTimestamp.Seconds)
+
+	// Without any event nor listing, app is pruned too.
+	now = now.Add(eventsRetention + time.Second)
+	assert.Nil(t, w.metrics())
+}
+
+func TestEventsWatcherNoMetricsWithoutEvents(t *testing.T) {
+	w := newTestEventsWatcher(nil)
+	assert.Nil(t, w.metrics())
+}
+
+func TestEventsWatcherReconnects(t *testing.T) {
+	d := &fakeEventsDocker{failures: 3}
+	w := newTestEventsWatcher(d)
+	w.start()
+	defer w.stop(context.Background())
+
+	start := fakeNow().UnixNano()
+	d.send(t, containerEvent("app", "oom", start+1))
+
+	// The daemon restarts.
+	d.mu.Lock()
+	d.errs <- fmt.Errorf("connection reset")
+	d.mu.Unlock()
+	require.Eventually(t, func() bool { return d.numCalls() == 5 }, time.Second, time.Millisecond)
+	d.send(t, containerEvent("app", "oom", start+1))
+	d.send(t, containerEvent("app", "kill", start+2))
+
+	require.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.counts[eventKey{"app", "kill"}] == 1
+	}, time.Second, time.Millisecond)
+	data := consumerdata.MetricsData{Metrics: w.metrics()}
+	verifyTimeSeriesValue(t, data, "container/events", []string{"app", "oom"}, 1)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	assert.Equal(t, eventsTimestamp(fakeNow()), d.opts[0].Since)
+	assert.Equal(t, eventsTimestamp(time.Unix(0, start+1)), d.opts[4].Since)
+	assert.Equal(t, []string{"container"}, d.opts[0].Filters.Get("type"))
+}
+
+func TestEventsWatcherStopWithoutStart(t *testing.T) {
+	w := newTestEventsWatcher(nil)
+	w.stop(context.Background())
+}
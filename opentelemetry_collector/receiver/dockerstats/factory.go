@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/docker/docker/api/types"
+
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config/configerror"
 	"go.opentelemetry.io/collector/config/configmodels"
@@ -28,7 +30,12 @@ func (f *Factory) CreateDefaultConfig() configmodels.Receiver {
 			TypeVal: receiverType,
 			NameVal: receiverType,
 		},
-		ScrapeInterval: 30 * time.Second,
+		ScrapeInterval:       30 * time.Second,
+		Mode:                 modePoll,
+		CgroupRoot:           "/sys/fs/cgroup",
+		MaxConcurrentScrapes: 4,
+		SharedNetwork:        sharedNetworkReport,
+		DaemonScrapeInterval: 5 * time.Minute,
 	}
 }
 
@@ -50,11 +57,54 @@ func (f *Factory) CreateMetricsReceiver(ctx context.Context, params component.Re
 	if c.ScrapeInterval <= 0 {
 		return nil, fmt.Errorf("invalid scrape duration: %v, must be positive", c.ScrapeInterval)
 	}
+	if c.Mode != modePoll && c.Mode != modeStream && c.Mode != modeCgroup {
+		return nil, fmt.Errorf("invalid mode: %q, must be %q, %q or %q", c.Mode, modePoll, modeStream, modeCgroup)
+	}
+	if c.MaxConcurrentScrapes <= 0 {
+		return nil, fmt.Errorf("invalid max_concurrent_scrapes: %d, must be positive", c.MaxConcurrentScrapes)
+	}
+	if c.SharedNetwork != sharedNetworkReport && c.SharedNetwork != sharedNetworkLabel && c.SharedNetwork != sharedNetworkSuppress {
+		return nil, fmt.Errorf("invalid shared_network: %q, must be %q, %q or %q", c.SharedNetwork, sharedNetworkReport, sharedNetworkLabel, sharedNetworkSuppress)
+	}
+	if c.DaemonMetrics && c.DaemonScrapeInterval <= 0 {
+		return nil, fmt.Errorf("invalid daemon scrape duration: %v, must be positive", c.DaemonScrapeInterval)
+	}
+	if c.InspectCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid inspect_cache_ttl: %v, must not be negative", c.InspectCacheTTL)
+	}
+	if err := validateClientConfig(c); err != nil {
+		return nil, err
+	}
+	if _, err := newContainerFilter(c.Include); err != nil {
+		return nil, fmt.Errorf("invalid include filter: %v", err)
+	}
+	if _, err := newContainerFilter(c.Exclude); err != nil {
+		return nil, fmt.Errorf("invalid exclude filter: %v", err)
+	}
+
+	endpoint, err := newDockerEndpoint(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize docker client: %v", err)
+	}
+	docker, err := endpoint.newClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize docker client: %v", err)
+	}
 
-	s, err := newScraper(c.ScrapeInterval, nextConsumer)
+	s, err := newScraper(c, docker, nextConsumer, params.Logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create dockerstats scraper: %v", err)
 	}
 
-	return &Receiver{scraper: s}, nil
+	r := &Receiver{scraper: s}
+	if c.WatchEvents {
+		r.events = newEventsWatcher(docker, func(c *types.Container) bool {
+			return s.shouldScrape(c, containerName(c))
+		})
+		s.events = r.events
+	}
+	if c.DaemonMetrics {
+		r.daemon = newDaemonScraper(c.DaemonScrapeInterval, &daemonClient{Client: docker, endpoint: endpoint}, nextConsumer, params.Logger)
+	}
+	return r, nil
 }
@@ -3,6 +3,7 @@ package dockerstats
 import (
 	"context"
 	"testing"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config/configerror"
@@ -20,6 +21,7 @@ func TestCreateDefaultConfig(t *testing.T) {
 	assert.NoError(t, configcheck.ValidateConfig(cfg))
 	c := cfg.(*Config)
 	assert.Greater(t, int64(c.ScrapeInterval), int64(0))
+	assert.Greater(t, c.MaxConcurrentScrapes, 0)
 }
 
 func TestCreateTraceReceiver(t *testing.T) {
@@ -41,3 +43,95 @@ func TestCreateMetricsReceiver(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, r)
 }
+
+func TestCreateMetricsReceiverCgroupMode(t *testing.T) {
+	factory := &Factory{}
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.Mode = modeCgroup
+	params := component.ReceiverCreateParams{Logger: zap.NewNop()}
+
+	r, err := factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Nil(t, err)
+	rcv := r.(*Receiver)
+	assert.NotNil(t, rcv.scraper.cgroups)
+	assert.Equal(t, "/sys/fs/cgroup", rcv.scraper.cgroups.root)
+	assert.Nil(t, rcv.scraper.streamer)
+}
+
+func TestCreateMetricsReceiverWithDaemonMetrics(t *testing.T) {
+	factory := &Factory{}
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.DaemonMetrics = true
+	params := component.ReceiverCreateParams{Logger: zap.NewNop()}
+
+	r, err := factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Nil(t, err)
+	rcv := r.(*Receiver)
+	assert.NotNil(t, rcv.daemon)
+	assert.Equal(t, 5*time.Minute, rcv.daemon.scrapeInterval)
+
+	cfg.DaemonScrapeInterval = 0
+	r, err = factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+}
+
+func TestCreateMetricsReceiverWithEvents(t *testing.T) {
+	factory := &Factory{}
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.WatchEvents = true
+	params := component.ReceiverCreateParams{Logger: zap.NewNop()}
+
+	r, err := factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Nil(t, err)
+	rcv := r.(*Receiver)
+	assert.NotNil(t, rcv.events)
+	assert.Equal(t, rcv.events, rcv.scraper.events)
+}
+
+func TestCreateMetricsReceiverInvalidConfig(t *testing.T) {
+	factory := &Factory{}
+	params := component.ReceiverCreateParams{Logger: zap.NewNop()}
+
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.Include = &ContainerFilter{Names: []string{"[a-"}}
+	r, err := factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+
+	cfg = factory.CreateDefaultConfig().(*Config)
+	cfg.Mode = "push"
+	r, err = factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+
+	cfg = factory.CreateDefaultConfig().(*Config)
+	cfg.SharedNetwork = "drop"
+	r, err = factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+
+	cfg = factory.CreateDefaultConfig().(*Config)
+	cfg.MaxConcurrentScrapes = 0
+	r, err = factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+
+	cfg = factory.CreateDefaultConfig().(*Config)
+	cfg.APIVersion = "latest"
+	r, err = factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+
+	cfg = factory.CreateDefaultConfig().(*Config)
+	cfg.TLS = &TLSConfig{CAFile: "testdata/missing.pem"}
+	r, err = factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+
+	cfg = factory.CreateDefaultConfig().(*Config)
+	cfg.Exclude = &ContainerFilter{Labels: []string{"=x"}}
+	r, err = factory.CreateMetricsReceiver(context.Background(), params, cfg, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+}
@@ -0,0 +1,73 @@
+package dockerstats
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// containerFilter is the compiled form of a ContainerFilter.
+type containerFilter struct {
+	names  []*regexp.Regexp
+	images map[string]bool
+	labels map[string]string
+}
+
+// newContainerFilter compiles f. It returns nil if f is nil or empty, which
+// is treated as "no filter" by the scraper.
+func newContainerFilter(f *ContainerFilter) (*containerFilter, error) {
+	if f == nil || (len(f.Names) == 0 && len(f.Images) == 0 && len(f.Labels) == 0) {
+		return nil, nil
+	}
+
+	cf := &containerFilter{
+		images: make(map[string]bool),
+		labels: make(map[string]string),
+	}
+	for _, n := range f.Names {
+		re, err := regexp.Compile(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid container name regex %q: %v", n, err)
+		}
+		cf.names = append(cf.names, re)
+	}
+	for _, img := range f.Images {
+		if img == "" {
+			return nil, fmt.Errorf("empty image name")
+		}
+		cf.images[img] = true
+	}
+	for _, l := range f.Labels {
+		kv := strings.SplitN(l, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("invalid label filter %q, must be key or key=value", l)
+		}
+		if len(kv) == 1 {
+			cf.labels[kv[0]] = ""
+		} else {
+			cf.labels[kv[0]] = kv[1]
+		}
+	}
+	return cf, nil
+}
+
+// matches returns whether the container matches any of the name, image or
+// label criteria of the filter.
+func (f *containerFilter) matches(c *types.Container, name string) bool {
+	for _, re := range f.names {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	if f.images[c.Image] {
+		return true
+	}
+	for k, v := range f.labels {
+		if lv, ok := c.Labels[k]; ok && (v == "" || lv == v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package dockerstats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Bucket scheme of the image age distribution, in days, the same as the
+// vm_image_ages metric.
+var (
+	imageAgeNumBounds  = float64(8)
+	imageAgeBoundsBase = float64(2)
+)
+
+// imageCreatedCache caches the creation time of images by ID. Images are
+// immutable, so entries only need to be removed once no container uses them.
+type imageCreatedCache struct {
+	mu      sync.Mutex
+	created map[string]time.Time
+}
+
+func newImageCreatedCache() *imageCreatedCache {
+	return &imageCreatedCache{created: make(map[string]time.Time)}
+}
+
+// get returns the creation time of the image, inspecting it if it is not
+// cached.
+func (c *imageCreatedCache) get(ctx context.Context, docker client.ImageAPIClient, image string) (time.Time, error) {
+	c.mu.Lock()
+	t, ok := c.created[image]
+	c.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	inspect, _, err := docker.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to inspect image: %v", err)
+	}
+	t, err = time.Parse(time.RFC3339Nano, inspect.Created)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse image creation time (%s): %v", inspect.Created, err)
+	}
+
+	c.mu.Lock()
+	c.created[image] = t
+	c.mu.Unlock()
+	return t, nil
+}
+
+// prune removes the images that are not in images.
+func (c *imageCreatedCache) prune(images map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for image := range c.created {
+		if !images[image] {
+			delete(c.created, image)
+		}
+	}
+}
+
+// imageAgeDays returns the age in days of an image created at created.
+func imageAgeDays(created, now time.Time) (float64, error) {
+	days := now.Sub(created).Hours() / 24
+	if days < 0 {
+		return 0, fmt.Errorf("image creation time %v is after the current time %v", created, now)
+	}
+	return days, nil
+}
@@ -0,0 +1,86 @@
+package dockerstats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mpb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+	"github.com/googlecloudplatform/appengine-sidecars-docker/opentelemetry_collector/receiver/metricgenerator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+)
+
+func newImageAgeTestScraper(d *fakeDocker, c *fakeMetricsConsumer) *scraper {
+	return &scraper{
+		startTime:          fakeNow(),
+		metricConsumer:     c,
+		docker:             d,
+		scrapeInterval:     10 * time.Second,
+		images:             newImageCreatedCache(),
+		imageBucketOptions: metricgenerator.MakeExponentialBucketOptions(imageAgeBoundsBase, imageAgeNumBounds),
+		now:                fakeNow,
+	}
+}
+
+func TestScraperExportImageAge(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	d := &fakeDocker{}
+	s := newImageAgeTestScraper(d, c)
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	ts := findTimeSeries(data, "container/image/age", []string{"name1a", "app:v1"})
+	require.NotNil(t, ts)
+	dist := ts.Points[0].GetDistributionValue()
+	assert.Equal(t, int64(1), dist.Count)
+	assert.Equal(t, float64(31), dist.Sum)
+	assert.Equal(t, []float64{1, 2, 4, 8, 16, 32, 64, 128, 256}, dist.GetBucketOptions().GetExplicit().Bounds)
+	// 31 days is in the [16, 32) bucket.
+	assert.Equal(t, int64(1), dist.Buckets[5].Count)
+	// The image of name3 cannot be inspected.
+	assert.Nil(t, findTimeSeries(data, "container/image/age", []string{"name3", ""}))
+
+	// The creation time of the image is cached. The images of name3 and
+	// fluentd cannot be inspected and are retried.
+	d.mu.Lock()
+	inspects := d.imageInspects
+	d.mu.Unlock()
+	s.export(context.Background())
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	assert.Equal(t, inspects+2, d.imageInspects)
+}
+
+func TestScraperExportImageAgeWithImageLabels(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := newImageAgeTestScraper(&fakeDocker{}, c)
+	s.imageLabels = true
+	s.extraLabelKeys = []*mpb.LabelKey{imageLabel, imageIDLabel}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	assert.NotNil(t, findTimeSeries(data, "container/image/age", []string{"name1a", "app:v1", "sha256:aaa"}))
+}
+
+func TestImageCreatedCachePrune(t *testing.T) {
+	cache := newImageCreatedCache()
+	cache.created["sha256:aaa"] = fakeNow()
+	cache.created["sha256:bbb"] = fakeNow()
+
+	cache.prune(map[string]bool{"sha256:bbb": true})
+	assert.Equal(t, map[string]time.Time{"sha256:bbb": fakeNow()}, cache.created)
+}
+
+func TestImageAgeDays(t *testing.T) {
+	age, err := imageAgeDays(fakeNow().Add(-36*time.Hour), fakeNow())
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, age)
+
+	_, err = imageAgeDays(fakeNow().Add(time.Hour), fakeNow())
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package dockerstats
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// statusDetailsRegexp matches the parenthesized details of a container
+// status, e.g. the health status in "Up 2 hours (healthy)" or the exit code
+// in "Exited (137) 5 minutes ago".
+var statusDetailsRegexp = regexp.MustCompile(`\([^)]*\)`)
+
+// statusKey returns the part of the state and status of a container, as
+// reported by the container list, whose change invalidates its inspect data.
+// The human readable durations of the status are ignored, as they change on
+// every scrape.
+func statusKey(c *types.Container) string {
+	return c.State + " " + strings.Join(statusDetailsRegexp.FindAllString(c.Status, -1), " ")
+}
+
+// statusDurationRegexp matches the human readable duration of a container
+// status: the time since the container started in "Up 2 hours (healthy)", or
+// since it finished in "Exited (137) 5 minutes ago".
+var statusDurationRegexp = regexp.MustCompile(`^(?:Up (.+?)|(?:Exited|Restarting) \(-?\d+\) (.+?) ago)(?: \(.*\))?$`)
+
+// statusClockSlack is added to the durations read from a container status to
+// account for the time between the container list and the cache lookup.
+const statusClockSlack = 5 * time.Second
+
+// statusDuration returns the upper bound of the duration in the status of a
+// container, and whether it is the time since the container started (true)
+// or finished (false). ok is false if the status has no duration.
+func statusDuration(status string) (d time.Duration, started bool, ok bool) {
+	m := statusDurationRegexp.FindStringSubmatch(status)
+	if m == nil {
+		return 0, false, false
+	}
+	if m[1] != "" {
+		d, ok = humanDurationBound(m[1])
+		return d, true, ok
+	}
+	d, ok = humanDurationBound(m[2])
+	return d, false, ok
+}
+
+// humanDurationBound returns the largest duration that docker formats as s,
+// e.g. 3 minutes for "2 minutes". See HumanDuration in
+// github.com/docker/go-units.
+func humanDurationBound(s string) (time.Duration, bool) {
+	switch s {
+	case "Less than a second":
+		return time.Second, true
+	case "About a minute":
+		return 2 * time.Minute, true
+	case "About an hour":
+		return 90 * time.Minute, true
+	}
+	var n int64
+	var unit string
+	if _, err := fmt.Sscanf(s, "%d %s", &n, &unit); err != nil {
+		return 0, false
+	}
+	day := 24 * time.Hour
+	switch strings.TrimSuffix(unit, "s") {
+	case "second":
+		return time.Duration(n+1) * time.Second, true
+	case "minute":
+		return time.Duration(n+1) * time.Minute, true
+	case "hour":
+		// Hours are rounded to the nearest.
+		return time.Duration(n)*time.Hour + 30*time.Minute, true
+	case "day":
+		return time.Duration(n+1) * day, true
+	case "week":
+		return time.Duration(n+1) * 7 * day, true
+	case "month":
+		return time.Duration(n+1) * 30 * day, true
+	case "year":
+		return time.Duration(n+1) * 365 * day, true
+	}
+	return 0, false
+}
+
+// restartedSince returns whether the status of a container shows that it
+// started or finished after the times recorded in info, e.g. "Up 5 seconds"
+// for a container that was inspected an hour after it started.
+func restartedSince(c *types.Container, info containerInfo, now time.Time) bool {
+	d, started, ok := statusDuration(c.Status)
+	if !ok {
+		return false
+	}
+	since := info.finishedAt
+	if started {
+		since = info.startedAt
+	}
+	if since.IsZero() {
+		return false
+	}
+	return now.Sub(since) > d+statusClockSlack
+}
+
+type inspectEntry struct {
+	info    containerInfo
+	key     string
+	fetched time.Time
+}
+
+// inspectCache caches the container info read by ContainerInspect. An entry
+// is used until the container state or status changes, or it is older than
+// the TTL. A restart between two scrapes that leaves the container running is
+// noticed from the time since start in the status, which is shorter than the
+// time since the cached start time.
+type inspectCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]inspectEntry
+}
+
+func newInspectCache(ttl time.Duration) *inspectCache {
+	return &inspectCache{
+		ttl:     ttl,
+		entries: make(map[string]inspectEntry),
+	}
+}
+
+// get returns the cached info of the container, if it is still valid at now.
+func (c *inspectCache) get(container *types.Container, now time.Time) (containerInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[container.ID]
+	if !ok || e.key != statusKey(container) || now.Sub(e.fetched) >= c.ttl || restartedSince(container, e.info, now) {
+		return containerInfo{}, false
+	}
+	return e.info, true
+}
+
+// put caches the info of the container read at now.
+func (c *inspectCache) put(container *types.Container, info containerInfo, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[container.ID] = inspectEntry{info: info, key: statusKey(container), fetched: now}
+}
+
+// prune removes the containers that are not in ids.
+func (c *inspectCache) prune(ids map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.entries {
+		if !ids[id] {
+			delete(c.entries, id)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package dockerstats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+)
+
+func (d *fakeDocker) numInspects() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inspects
+}
+
+func (d *fakeDocker) setStatus(id, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.statuses == nil {
+		d.statuses = make(map[string]string)
+	}
+	d.statuses[id] = status
+}
+
+func (d *fakeDocker) setStartedAt(id, startedAt string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.startedAts == nil {
+		d.startedAts = make(map[string]string)
+	}
+	d.startedAts[id] = startedAt
+}
+
+func TestScraperExportInspectCache(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	d := &fakeDocker{}
+	now := fakeNow()
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         d,
+		scrapeInterval: 10 * time.Second,
+		inspects:       newInspectCache(time.Minute),
+		now:            func() time.Time { return now },
+	}
+
+	// All the containers are inspected on the first scrape. The inspection
+	// of id3 fails and is retried on every scrape.
+	s.export(context.Background())
+	assert.Equal(t, 4, d.numInspects())
+	now = now.Add(10 * time.Second)
+	s.export(context.Background())
+	assert.Equal(t, 5, d.numInspects())
+
+	// The uptime is still up to date.
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/uptime", "name1a", 12*3600+10)
+	verifyContainerMetricValue(t, data, "container/restart_count", "name1a", 3)
+
+	// A status change of id1 invalidates its entry, not a duration change.
+	d.setStatus("id2", "Up 24 hours")
+	d.setStatus("id1", "Up 12 hours (unhealthy)")
+	s.export(context.Background())
+	assert.Equal(t, 7, d.numInspects())
+	d.setStatus("id1", "Up 13 hours (unhealthy)")
+	s.export(context.Background())
+	assert.Equal(t, 8, d.numInspects())
+
+	// The entries expire after the TTL.
+	now = now.Add(time.Minute)
+	s.export(context.Background())
+	assert.Equal(t, 12, d.numInspects())
+}
+
+func TestScraperExportInspectCacheRestart(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	d := &fakeDocker{}
+	now := fakeNow()
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         d,
+		scrapeInterval: 10 * time.Second,
+		inspects:       newInspectCache(time.Hour),
+		now:            func() time.Time { return now },
+	}
+
+	d.setStatus("id2", "Up 24 hours")
+	s.export(context.Background())
+	assert.Equal(t, 4, d.numInspects())
+
+	// id2 restarts between two scrapes and is still running.
+	now = now.Add(10 * time.Second)
+	d.setStatus("id2", "Up 3 seconds")
+	d.setStartedAt("id2", now.Add(-3*time.Second).Format(time.RFC3339Nano))
+	s.export(context.Background())
+	assert.Equal(t, 6, d.numInspects())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/uptime", "id2", 3)
+	verifyContainerMetricValue(t, data, "container/restart_count", "id2", 6)
+
+	// The new entry is used again.
+	now = now.Add(10 * time.Second)
+	d.setStatus("id2", "Up 13 seconds")
+	s.export(context.Background())
+	assert.Equal(t, 7, d.numInspects())
+}
+
+func TestStatusDuration(t *testing.T) {
+	for _, tc := range []struct {
+		status  string
+		d       time.Duration
+		started bool
+		ok      bool
+	}{
+		{"Up Less than a second", time.Second, true, true},
+		{"Up 5 seconds", 6 * time.Second, true, true},
+		{"Up About a minute (healthy)", 2 * time.Minute, true, true},
+		{"Up 3 hours (Paused)", 3*time.Hour + 30*time.Minute, true, true},
+		{"Up 2 weeks", 21 * 24 * time.Hour, true, true},
+		{"Exited (137) 5 minutes ago", 6 * time.Minute, false, true},
+		{"Restarting (1) About an hour ago", 90 * time.Minute, false, true},
+		{"Created", 0, false, false},
+		{"Up forever", 0, true, false},
+	} {
+		d, started, ok := statusDuration(tc.status)
+		assert.Equal(t, tc.ok, ok, tc.status)
+		if tc.ok {
+			assert.Equal(t, tc.d, d, tc.status)
+			assert.Equal(t, tc.started, started, tc.status)
+		}
+	}
+}
+
+func TestScraperExportWithoutInspectCache(t *testing.T) {
+	d := &fakeDocker{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: &fakeMetricsConsumer{},
+		docker:         d,
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+	s.export(context.Background())
+	assert.Equal(t, 8, d.numInspects())
+}
+
+func TestStatusKey(t *testing.T) {
+	assert.Equal(t,
+		statusKey(&types.Container{State: "running", Status: "Up 2 seconds (healthy)"}),
+		statusKey(&types.Container{State: "running", Status: "Up 3 hours (healthy)"}))
+	assert.NotEqual(t,
+		statusKey(&types.Container{State: "running", Status: "Up 3 hours (healthy)"}),
+		statusKey(&types.Container{State: "running", Status: "Up 3 hours (unhealthy)"}))
+	assert.NotEqual(t,
+		statusKey(&types.Container{State: "exited", Status: "Exited (0) 1 minute ago"}),
+		statusKey(&types.Container{State: "exited", Status: "Exited (137) 1 minute ago"}))
+	assert.NotEqual(t,
+		statusKey(&types.Container{State: "running", Status: "Up 1 minute"}),
+		statusKey(&types.Container{State: "paused", Status: "Up 1 minute"}))
+}
+
+func TestInspectCachePrune(t *testing.T) {
+	cache := newInspectCache(time.Minute)
+	c1, c2 := &types.Container{ID: "id1"}, &types.Container{ID: "id2"}
+	cache.put(c1, containerInfo{restartCount: 1}, fakeNow())
+	cache.put(c2, containerInfo{restartCount: 2}, fakeNow())
+
+	cache.prune(map[string]bool{"id2": true})
+	_, ok := cache.get(c1, fakeNow())
+	assert.False(t, ok)
+	info, ok := cache.get(c2, fakeNow())
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), info.restartCount)
+}
@@ -0,0 +1,38 @@
+package dockerstats
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// logFileSize returns the size of the container log file at path, plus the
+// size of its rotated files (path.1, path.2.gz, ...) kept by the json-file
+// logging driver.
+func logFileSize(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat log file: %v", err)
+	}
+	size := fi.Size()
+
+	dir, base := filepath.Split(path)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list log directory: %v", err)
+	}
+	for _, e := range entries {
+		suffix := strings.TrimPrefix(e.Name(), base+".")
+		if suffix == e.Name() || e.IsDir() {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(suffix, ".gz")); err != nil {
+			continue
+		}
+		size += e.Size()
+	}
+	return size, nil
+}
@@ -8,9 +8,13 @@ import (
 )
 
 // Receiver implements component.MetricReceiver.
-// Manages the lifecycle of the scraper that scrapes docker stats from the API.
+// Manages the lifecycle of the scraper that scrapes docker stats from the API,
+// of the docker events watcher if events are enabled, and of the daemon
+// metrics scraper if daemon metrics are enabled.
 type Receiver struct {
 	scraper *scraper
+	events  *eventsWatcher
+	daemon  *daemonScraper
 
 	startOnce sync.Once
 	stopOnce  sync.Once
@@ -19,15 +23,36 @@ type Receiver struct {
 // Start tells this receiver to start.
 func (r *Receiver) Start(ctx context.Context, host component.Host) error {
 	r.startOnce.Do(func() {
+		if r.events != nil {
+			r.events.start()
+		}
 		r.scraper.start()
+		if r.daemon != nil {
+			r.daemon.start()
+		}
 	})
 	return nil
 }
 
-// Shutdown tells this receiver to stop.
+// Shutdown tells this receiver to stop. In-flight docker API calls are
+// cancelled, and Shutdown returns the error of ctx if it is done before the
+// scrapers have stopped. It is safe to call without Start.
 func (r *Receiver) Shutdown(ctx context.Context) error {
+	var err error
 	r.stopOnce.Do(func() {
-		r.scraper.stop()
+		errs := []error{r.scraper.stop(ctx)}
+		if r.daemon != nil {
+			errs = append(errs, r.daemon.stop(ctx))
+		}
+		if r.events != nil {
+			errs = append(errs, r.events.stop(ctx))
+		}
+		for _, e := range errs {
+			if e != nil {
+				err = e
+				break
+			}
+		}
 	})
-	return nil
+	return err
 }
@@ -0,0 +1,31 @@
+package dockerstats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestReceiverShutdownWithoutStart(t *testing.T) {
+	r := &Receiver{
+		scraper: newBlockingScraper(&blockingDocker{}),
+		daemon:  newDaemonScraper(time.Minute, &fakeDaemonDocker{}, nil, zap.NewNop()),
+		events:  newTestEventsWatcher(nil),
+	}
+	assert.NoError(t, r.Shutdown(context.Background()))
+}
+
+func TestReceiverShutdownDeadline(t *testing.T) {
+	d := &blockingDocker{listing: make(chan struct{}), release: make(chan struct{})}
+	defer close(d.release)
+	r := &Receiver{scraper: newBlockingScraper(d)}
+	assert.NoError(t, r.Start(context.Background(), nil))
+	<-d.listing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, r.Shutdown(ctx))
+}
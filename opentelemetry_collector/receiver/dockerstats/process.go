@@ -0,0 +1,55 @@
+package dockerstats
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// processStats are the stats of the main process of a container read from
+// the host /proc filesystem.
+type processStats struct {
+	openFDs int64
+	threads int64
+}
+
+// readProcessStats reads the number of open file descriptors and threads of
+// process pid from procPath, the mount point of the host /proc.
+func readProcessStats(procPath string, pid int) (processStats, error) {
+	var ps processStats
+	dir := filepath.Join(procPath, strconv.Itoa(pid))
+
+	fds, err := ioutil.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return ps, fmt.Errorf("failed to list open file descriptors: %v", err)
+	}
+	ps.openFDs = int64(len(fds))
+
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return ps, fmt.Errorf("failed to open process status: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Threads:") {
+			continue
+		}
+		threads, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "Threads:")), 10, 64)
+		if err != nil {
+			return ps, fmt.Errorf("failed to parse thread count %q: %v", line, err)
+		}
+		ps.threads = threads
+		return ps, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return ps, fmt.Errorf("failed to read process status: %v", err)
+	}
+	return ps, fmt.Errorf("no thread count in process status")
+}
@@ -0,0 +1,42 @@
+package dockerstats
+
+import (
+	mpb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+	rpb "github.com/census-instrumentation/opencensus-proto/gen-go/resource/v1"
+	"github.com/docker/docker/api/types"
+
+	"go.opentelemetry.io/collector/translator/conventions"
+)
+
+const (
+	// containerResourceType is the OpenCensus resource type of containers.
+	containerResourceType = "container"
+	// attributeContainerID is the semantic convention resource label of the
+	// container ID.
+	attributeContainerID = "container.id"
+)
+
+// containerResource returns the resource identifying the container.
+func containerResource(c *types.Container) *rpb.Resource {
+	return &rpb.Resource{
+		Type: containerResourceType,
+		Labels: map[string]string{
+			attributeContainerID:                c.ID,
+			conventions.AttributeContainerName:  containerName(c),
+			conventions.AttributeContainerImage: c.Image,
+		},
+	}
+}
+
+// removeContainerName removes the container_name label, the first label of
+// the container metrics, when the container is identified by the resource.
+func removeContainerName(metrics []*mpb.Metric) {
+	for _, m := range metrics {
+		d := *m.MetricDescriptor
+		d.LabelKeys = d.LabelKeys[1:]
+		m.MetricDescriptor = &d
+		for _, ts := range m.Timeseries {
+			ts.LabelValues = ts.LabelValues[1:]
+		}
+	}
+}
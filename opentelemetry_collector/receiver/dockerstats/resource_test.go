@@ -0,0 +1,71 @@
+package dockerstats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mpb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/consumer/consumerdata"
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+)
+
+func TestScraperExportResourcePerContainer(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:            fakeNow(),
+		metricConsumer:       c,
+		docker:               &fakeDocker{},
+		scrapeInterval:       10 * time.Second,
+		resourcePerContainer: true,
+		now:                  fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)
+	// id1, id2, id3 and the stopped fluentd container, then the scraper
+	// metrics.
+	require.Len(t, data, 5)
+
+	id1 := data[0]
+	require.NotNil(t, id1.Resource)
+	assert.Equal(t, "container", id1.Resource.Type)
+	assert.Equal(t, map[string]string{
+		"container.id":         "id1",
+		"container.name":       "name1a",
+		"container.image.name": "app:v1",
+	}, id1.Resource.Labels)
+	verifyTimeSeriesValue(t, id1, "container/memory/usage", nil, 33)
+	verifyTimeSeriesValue(t, id1, "container/blkio/bytes", []string{"8:0", "read"}, 4096)
+	verifyTimeSeriesValue(t, id1, "container/state", []string{"running"}, 0)
+	for _, m := range id1.Metrics {
+		for _, k := range m.MetricDescriptor.LabelKeys {
+			assert.NotEqual(t, "container_name", k.Key, m.MetricDescriptor.Name)
+		}
+	}
+	// Package level descriptors must not be modified.
+	assert.Equal(t, []*mpb.LabelKey{containerNameLabel}, memUsageDesc.LabelKeys)
+
+	fluentd := data[3]
+	assert.Equal(t, "fluentd", fluentd.Resource.Labels["container.name"])
+	verifyTimeSeriesValue(t, fluentd, "container/state", []string{"exited"}, 1)
+	verifyTimeSeriesAbsent(t, fluentd, "container/memory/usage", nil)
+
+	scraperData := data[4]
+	assert.Nil(t, scraperData.Resource)
+	verifyTimeSeriesValue(t, scraperData, "dockerstats/scrape/containers", nil, 2)
+}
+
+func TestRemoveContainerName(t *testing.T) {
+	s := &scraper{startTime: fakeNow(), now: fakeNow}
+	metrics := []*mpb.Metric{s.makeInt64Metric(uptimeDesc, 10, []*mpb.LabelValue{{Value: "app", HasValue: true}})}
+
+	removeContainerName(metrics)
+	assert.Empty(t, metrics[0].MetricDescriptor.LabelKeys)
+	verifyTimeSeriesValue(t, consumerdata.MetricsData{Metrics: metrics}, "container/uptime", nil, 10)
+	assert.Equal(t, []*mpb.LabelKey{containerNameLabel}, uptimeDesc.LabelKeys)
+}
@@ -2,19 +2,23 @@ package dockerstats
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/golang/glog"
 
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/consumer/consumerdata"
 	"go.opentelemetry.io/collector/consumer/pdatautil"
+	"go.uber.org/zap"
 
 	mpb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
 
@@ -26,6 +30,38 @@ var (
 		Key:         "container_name",
 		Description: "Name of the container (or ID if name is not available)",
 	}
+	imageLabel = &mpb.LabelKey{
+		Key:         "image",
+		Description: "Image the container was created from",
+	}
+	imageIDLabel = &mpb.LabelKey{
+		Key:         "image_id",
+		Description: "ID of the image the container was created from",
+	}
+	deviceLabel = &mpb.LabelKey{
+		Key:         "device",
+		Description: "Block device in major:minor form",
+	}
+	interfaceLabel = &mpb.LabelKey{
+		Key:         "interface",
+		Description: "Name of the network interface inside the container",
+	}
+	networkModeLabel = &mpb.LabelKey{
+		Key:         "network_mode",
+		Description: "Network namespace of the container: host, container (shared with another container) or private",
+	}
+	stateLabel = &mpb.LabelKey{
+		Key:         "state",
+		Description: "Container state (created, running, paused, restarting, removing, exited or dead)",
+	}
+	healthStatusLabel = &mpb.LabelKey{
+		Key:         "status",
+		Description: "Health check status (starting, healthy or unhealthy)",
+	}
+	opLabel = &mpb.LabelKey{
+		Key:         "op",
+		Description: "Block I/O operation (read or write)",
+	}
 
 	memUsageDesc = &mpb.MetricDescriptor{
 		Name:        "container/memory/usage",
@@ -41,18 +77,193 @@ var (
 		Type:        mpb.MetricDescriptor_GAUGE_INT64,
 		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
 	}
+	memReservationDesc = &mpb.MetricDescriptor{
+		Name:        "container/memory/reservation",
+		Description: "Memory soft limit of the container, enforced when the host is low on memory",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	memRSSDesc = &mpb.MetricDescriptor{
+		Name:        "container/memory/rss",
+		Description: "Anonymous memory (RSS) used by the container",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	memCacheDesc = &mpb.MetricDescriptor{
+		Name:        "container/memory/cache",
+		Description: "Page cache memory used by the container",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	memSwapDesc = &mpb.MetricDescriptor{
+		Name:        "container/memory/swap",
+		Description: "Swap used by the container",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	memWorkingSetDesc = &mpb.MetricDescriptor{
+		Name:        "container/memory/working_set",
+		Description: "Memory the container is actively using: usage minus inactive page cache",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	memFailcntDesc = &mpb.MetricDescriptor{
+		Name:        "container/memory/failcnt",
+		Description: "Number of times the container memory usage hit its limit",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
 	nwRecvBytesDesc = &mpb.MetricDescriptor{
 		Name:        "container/network/received_bytes",
-		Description: "Bytes received by container over all network interfaces",
+		Description: "Bytes received by container over its network interfaces",
 		Unit:        "byte",
 		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
 		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
 	}
 	nwSentBytesDesc = &mpb.MetricDescriptor{
 		Name:        "container/network/sent_bytes",
-		Description: "Bytes sent by container over all network interfaces",
+		Description: "Bytes sent by container over its network interfaces",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	nwRecvPacketsDesc = &mpb.MetricDescriptor{
+		Name:        "container/network/received_packets",
+		Description: "Packets received by container over its network interfaces",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	nwSentPacketsDesc = &mpb.MetricDescriptor{
+		Name:        "container/network/sent_packets",
+		Description: "Packets sent by container over its network interfaces",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	nwRecvErrorsDesc = &mpb.MetricDescriptor{
+		Name:        "container/network/received_errors",
+		Description: "Receive errors on the container network interfaces",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	nwSentErrorsDesc = &mpb.MetricDescriptor{
+		Name:        "container/network/sent_errors",
+		Description: "Transmit errors on the container network interfaces",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	nwRecvDroppedDesc = &mpb.MetricDescriptor{
+		Name:        "container/network/received_dropped",
+		Description: "Incoming packets dropped on the container network interfaces",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	nwSentDroppedDesc = &mpb.MetricDescriptor{
+		Name:        "container/network/sent_dropped",
+		Description: "Outgoing packets dropped on the container network interfaces",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	cpuUsageTimeDesc = &mpb.MetricDescriptor{
+		Name:        "container/cpu/usage_time",
+		Description: "Total CPU time consumed by the container",
+		Unit:        "ns",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	cpuUtilizationDesc = &mpb.MetricDescriptor{
+		Name:        "container/cpu/utilization",
+		Description: "Percentage of host CPU used by the container since the previous stats sample, where 100% is one fully used core",
+		Unit:        "%",
+		Type:        mpb.MetricDescriptor_GAUGE_DOUBLE,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	cpuLimitDesc = &mpb.MetricDescriptor{
+		Name:        "container/cpu/limit",
+		Description: "Number of CPU cores the container is allowed to use",
+		Unit:        "1",
+		Type:        mpb.MetricDescriptor_GAUGE_DOUBLE,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	cpuSharesDesc = &mpb.MetricDescriptor{
+		Name:        "container/cpu/shares",
+		Description: "Relative CPU weight of the container when CPU time is contended",
+		Unit:        "1",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	cpuThrottledPeriodsDesc = &mpb.MetricDescriptor{
+		Name:        "container/cpu/throttled_periods",
+		Description: "Number of CPU enforcement periods in which the container was throttled",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	cpuThrottledTimeDesc = &mpb.MetricDescriptor{
+		Name:        "container/cpu/throttled_time",
+		Description: "Total time the container was throttled for",
+		Unit:        "ns",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	blkioBytesDesc = &mpb.MetricDescriptor{
+		Name:        "container/blkio/bytes",
+		Description: "Bytes transferred to and from block devices by the container",
 		Unit:        "byte",
 		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel, deviceLabel, opLabel},
+	}
+	blkioOperationsDesc = &mpb.MetricDescriptor{
+		Name:        "container/blkio/operations",
+		Description: "Number of I/O operations performed on block devices by the container",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel, deviceLabel, opLabel},
+	}
+	pidsCurrentDesc = &mpb.MetricDescriptor{
+		Name:        "container/pids/current",
+		Description: "Number of processes and threads in the container",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	pidsLimitDesc = &mpb.MetricDescriptor{
+		Name:        "container/pids/limit",
+		Description: "Maximum number of processes and threads allowed in the container",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	pidsConfiguredLimitDesc = &mpb.MetricDescriptor{
+		Name:        "container/pids/configured_limit",
+		Description: "Maximum number of processes and threads configured for the container",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	processOpenFDsDesc = &mpb.MetricDescriptor{
+		Name:        "container/process/open_fds",
+		Description: "Number of file descriptors opened by the main process of the container",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	processThreadsDesc = &mpb.MetricDescriptor{
+		Name:        "container/process/threads",
+		Description: "Number of threads of the main process of the container",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
 		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
 	}
 	// Container health metrics.
@@ -70,130 +281,760 @@ var (
 		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
 		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
 	}
+	containerStateDesc = &mpb.MetricDescriptor{
+		Name:        "container/state",
+		Description: "Number of containers with this name in the given state.",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel, stateLabel},
+	}
+	oomKilledDesc = &mpb.MetricDescriptor{
+		Name:        "container/oom_killed",
+		Description: "Whether the last exit of the container was caused by the OOM killer (1) or not (0).",
+		Unit:        "1",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	exitCodeDesc = &mpb.MetricDescriptor{
+		Name:        "container/exit_code",
+		Description: "Exit code of the last run of the container.",
+		Unit:        "1",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	healthStatusDesc = &mpb.MetricDescriptor{
+		Name:        "container/health/status",
+		Description: "Health check status of the container: 1 for the current status, 0 for the others.",
+		Unit:        "1",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel, healthStatusLabel},
+	}
+	healthFailingStreakDesc = &mpb.MetricDescriptor{
+		Name:        "container/health/failing_streak",
+		Description: "Number of consecutive failed health checks of the container.",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	imageAgeDesc = &mpb.MetricDescriptor{
+		Name:        "container/image/age",
+		Description: "Age of the image of the container, since the image was built.",
+		Unit:        "Days",
+		Type:        mpb.MetricDescriptor_GAUGE_DISTRIBUTION,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	diskRwDesc = &mpb.MetricDescriptor{
+		Name:        "container/disk/rw_bytes",
+		Description: "Size of the files created or changed in the writable layer of the container.",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	diskRootFsDesc = &mpb.MetricDescriptor{
+		Name:        "container/disk/rootfs_bytes",
+		Description: "Total size of the container root filesystem, image layers included.",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+	logFileSizeDesc = &mpb.MetricDescriptor{
+		Name:        "container/log/file_bytes",
+		Description: "Size of the container log file, rotated files included.",
+		Unit:        "byte",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+		LabelKeys:   []*mpb.LabelKey{containerNameLabel},
+	}
+)
+
+// containerStates are the container states reported by container/state.
+var containerStates = []string{"created", "running", "paused", "restarting", "removing", "exited", "dead"}
+
+// healthStatuses are the health check statuses reported by
+// container/health/status.
+var healthStatuses = []string{types.Starting, types.Healthy, types.Unhealthy}
+
+// Keys of MemoryStats.Stats, in order of preference. The first keys are the
+// cgroup v1 names (hierarchical total first), the last ones the cgroup v2 names.
+var (
+	memRSSKeys          = []string{"total_rss", "rss", "anon"}
+	memCacheKeys        = []string{"total_cache", "cache", "file"}
+	memSwapKeys         = []string{"total_swap", "swap"}
+	memInactiveFileKeys = []string{"total_inactive_file", "inactive_file"}
+	// memV1Keys are only present in the memory stats of cgroup v1.
+	memV1Keys = []string{"hierarchical_memory_limit", "total_rss"}
 )
 
+// networkMetrics lists the network metrics and how to read their value from
+// the stats of one network interface.
+var networkMetrics = []struct {
+	desc  *mpb.MetricDescriptor
+	value func(types.NetworkStats) uint64
+}{
+	{nwRecvBytesDesc, func(n types.NetworkStats) uint64 { return n.RxBytes }},
+	{nwSentBytesDesc, func(n types.NetworkStats) uint64 { return n.TxBytes }},
+	{nwRecvPacketsDesc, func(n types.NetworkStats) uint64 { return n.RxPackets }},
+	{nwSentPacketsDesc, func(n types.NetworkStats) uint64 { return n.TxPackets }},
+	{nwRecvErrorsDesc, func(n types.NetworkStats) uint64 { return n.RxErrors }},
+	{nwSentErrorsDesc, func(n types.NetworkStats) uint64 { return n.TxErrors }},
+	{nwRecvDroppedDesc, func(n types.NetworkStats) uint64 { return n.RxDropped }},
+	{nwSentDroppedDesc, func(n types.NetworkStats) uint64 { return n.TxDropped }},
+}
+
 type containerInfo struct {
-	uptime       time.Duration
+	// startedAt and createdAt are zero if unknown.
+	startedAt time.Time
+	createdAt time.Time
+	// finishedAt is the time the container last stopped, zero if unknown.
+	finishedAt time.Time
+	// pid is the host PID of the main process of the container, 0 if it is
+	// not running.
+	pid          int
 	restartCount int64
+	oomKilled    bool
+	exitCode     int64
+	// healthStatus is empty if the container has no health check.
+	healthStatus  string
+	failingStreak int64
+	// sizeRw and sizeRootFs are only read in disk usage mode, nil otherwise.
+	sizeRw     *int64
+	sizeRootFs *int64
+	logPath    string
+	// The resource limits configured for the container, 0 if unlimited.
+	// cpuLimit is in cores.
+	cpuLimit          float64
+	cpuShares         int64
+	memoryReservation int64
+	pidsLimit         int64
+	// networkMode is empty if unknown.
+	networkMode container.NetworkMode
 }
 
 type scraper struct {
 	startTime      time.Time
 	scrapeInterval time.Duration
-	done           chan bool
-	scrapeCount    uint64
+	// timeout bounds the docker API requests of a scrape. The scrape interval
+	// is used if it is zero.
+	timeout time.Duration
+	// cancel cancels the context of the scrape loop and of its docker API
+	// calls, and wg waits for the loop to return.
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// scrapeCount is the number of scrapes done, accessed atomically.
+	scrapeCount uint64
+	// stats provides the container usage stats. The docker API is polled if
+	// it is nil.
+	stats statsSource
+	// streamer caches the stats of the containers in stream mode. It is nil
+	// in the other modes.
+	streamer *statsStreamer
+	// cgroups reads the container stats from the cgroup filesystem in cgroup
+	// mode. It is nil in the other modes.
+	cgroups *cgroupStatsSource
+	// listed is the last container list returned by the daemon, by ID. In
+	// cgroup mode, it provides the names, image and labels of the containers
+	// listed from the cgroup filesystem while the daemon is not responding.
+	listed map[string]types.Container
+	// events counts the container lifecycle events. It is nil if events are
+	// not watched. Its lifecycle is managed by the Receiver.
+	events *eventsWatcher
+	// starts tracks the start timestamp of the cumulative series of each
+	// container. If nil, the scraper start time is used.
+	starts *startTimeTracker
+	// maxConcurrentScrapes is the number of containers scraped in parallel.
+	maxConcurrentScrapes int
+
+	// perInterfaceNetwork reports network metrics for each interface instead
+	// of summing them over all interfaces of a container.
+	perInterfaceNetwork bool
+	// sharedNetwork is the handling of the network stats of containers
+	// sharing the host or another container network namespace.
+	sharedNetwork string
+	// hostProcPath is where the host /proc is mounted. Process stats are not
+	// collected if it is empty.
+	hostProcPath string
+	// diskUsage reads the container sizes and log file size.
+	diskUsage bool
+	// include and exclude select the containers to scrape. A nil filter
+	// matches no container for exclude and every container for include.
+	include *containerFilter
+	exclude *containerFilter
+
+	// containerLabels are the docker labels attached to the metrics, and
+	// imageLabels whether image and image_id are. extraLabelKeys are the
+	// corresponding metric label keys, inserted after container_name.
+	containerLabels []string
+	imageLabels     bool
+	extraLabelKeys  []*mpb.LabelKey
+
+	// inspects caches the container info. It is nil if caching is disabled.
+	inspects *inspectCache
+	// images caches the creation time of the container images.
+	images             *imageCreatedCache
+	imageBucketOptions *mpb.DistributionValue_BucketOptions
+
+	// resourcePerContainer sends the metrics of each container in its own
+	// batch, identified by a container resource instead of container_name.
+	resourcePerContainer bool
+	// self tracks the errors and successes of the scrapes.
+	self scrapeStats
 
 	metricConsumer consumer.MetricsConsumer
-	docker         client.ContainerAPIClient
+	docker         dockerAPIClient
+	logger         *zap.Logger
 
 	now func() time.Time
 }
 
-func newScraper(scrapeInterval time.Duration, metricConsumer consumer.MetricsConsumer) (*scraper, error) {
-	docker, err := client.NewEnvClient()
+// dockerAPIClient is the part of the docker API used by the scraper.
+type dockerAPIClient interface {
+	client.ContainerAPIClient
+	client.ImageAPIClient
+}
+
+func newScraper(cfg *Config, docker dockerAPIClient, metricConsumer consumer.MetricsConsumer, logger *zap.Logger) (*scraper, error) {
+	include, err := newContainerFilter(cfg.Include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include filter: %v", err)
+	}
+	exclude, err := newContainerFilter(cfg.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude filter: %v", err)
+	}
+
+	extraLabelKeys, err := makeExtraLabelKeys(cfg.ImageLabels, cfg.ContainerLabels)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize docker client: %v", err)
+		return nil, err
+	}
+
+	var source statsSource = &dockerStatsSource{docker: docker}
+	var streamer *statsStreamer
+	var cgroups *cgroupStatsSource
+	switch cfg.Mode {
+	case modeStream:
+		// Streams deliver a sample every second. A sample older than two
+		// scrape intervals comes from a stream that is stuck or cannot be
+		// reopened.
+		streamer = newStatsStreamer(docker, 2*cfg.ScrapeInterval)
+		source = streamer
+	case modeCgroup:
+		cgroups = &cgroupStatsSource{root: cfg.CgroupRoot, now: time.Now}
+		source = cgroups
+	}
+
+	var inspects *inspectCache
+	if cfg.InspectCacheTTL > 0 {
+		inspects = newInspectCache(cfg.InspectCacheTTL)
 	}
 
 	return &scraper{
-		scrapeInterval: scrapeInterval,
-		done:           make(chan bool),
-		metricConsumer: metricConsumer,
-		docker:         docker,
-		now:            time.Now,
+		scrapeInterval:       cfg.ScrapeInterval,
+		timeout:              cfg.Timeout,
+		stats:                source,
+		streamer:             streamer,
+		cgroups:              cgroups,
+		starts:               newStartTimeTracker(),
+		inspects:             inspects,
+		maxConcurrentScrapes: cfg.MaxConcurrentScrapes,
+		perInterfaceNetwork:  cfg.PerInterfaceNetwork,
+		sharedNetwork:        cfg.SharedNetwork,
+		hostProcPath:         cfg.HostProcPath,
+		diskUsage:            cfg.DiskUsage,
+		include:              include,
+		exclude:              exclude,
+		containerLabels:      cfg.ContainerLabels,
+		imageLabels:          cfg.ImageLabels,
+		resourcePerContainer: cfg.ResourcePerContainer,
+		extraLabelKeys:       extraLabelKeys,
+		metricConsumer:       metricConsumer,
+		images:               newImageCreatedCache(),
+		imageBucketOptions:   metricgenerator.MakeExponentialBucketOptions(imageAgeBoundsBase, imageAgeNumBounds),
+		docker:               docker,
+		logger:               logger,
+		now:                  time.Now,
 	}, nil
 }
 
 func (s *scraper) start() {
 	s.startTime = s.now()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
 	go func() {
+		defer s.wg.Done()
 		ticker := time.NewTicker(s.scrapeInterval)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				s.export()
-				s.scrapeCount++
-			case <-s.done:
+				s.export(ctx)
+				atomic.AddUint64(&s.scrapeCount, 1)
+			case <-ctx.Done():
 				return
 			}
 		}
 	}()
 }
 
-func (s *scraper) stop() {
-	s.done <- true
+// stop cancels the scrape loop, including an in-flight scrape, and waits for
+// it to return or for ctx to be done. It is safe to call without start.
+func (s *scraper) stop(ctx context.Context) error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.streamer != nil {
+		s.streamer.stop()
+	}
+	return waitContext(ctx, &s.wg)
+}
+
+// scrapes returns the number of scrapes done.
+func (s *scraper) scrapes() uint64 {
+	return atomic.LoadUint64(&s.scrapeCount)
 }
 
-func (s *scraper) export() {
+// waitContext waits for wg, or until ctx is done.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// export scrapes the stats of the containers and sends them to the metric
+// consumer. The docker API calls are cancelled when ctx is.
+func (s *scraper) export(ctx context.Context) {
 	glog.Info("Exporting docker stats as metrics.")
-	ctx, cancel := context.WithTimeout(context.Background(), s.scrapeInterval)
+	start := s.now()
+	ctx, cancel := context.WithTimeout(ctx, s.requestTimeout())
 	defer cancel()
 
-	containers, err := s.docker.ContainerList(ctx, types.ContainerListOptions{})
+	// In cgroup mode, the stats are still available when the docker daemon
+	// is not responding: the containers are then listed from the cgroup
+	// filesystem, without container info, and identified as in the last
+	// container list, or only by their ID if they were never listed.
+	inspect := true
+	containers, err := s.listContainers(ctx)
+	if err != nil {
+		s.self.countError(callList)
+	}
+	if err != nil && s.cgroups != nil {
+		glog.Warningf("Failed to get docker container list, listing container cgroups: %v", err)
+		inspect = false
+		containers, err = s.cgroups.listContainers()
+		for i := range containers {
+			if listed, ok := s.listed[containers[i].ID]; ok {
+				listed.State = containers[i].State
+				containers[i] = listed
+			}
+		}
+	} else if err == nil && s.cgroups != nil {
+		s.listed = make(map[string]types.Container, len(containers))
+		for _, container := range containers {
+			s.listed[container.ID] = container
+		}
+	}
 	if err != nil {
 		glog.Warningf("Failed to get docker container list: %v", err)
+		s.consume(ctx, []consumerdata.MetricsData{{Metrics: s.self.metrics(s.now().Sub(start), 0, s.startTime, s.now())}})
 		return
 	}
+	s.self.succeeded(start)
 
-	var metrics []*mpb.Metric
+	// All the selected containers are reported in the state inventory and
+	// inspected, so that the exit code and OOM kill of a stopped container
+	// are reported, but only the running ones have usage stats.
+	var selected, targets []types.Container
 	for _, container := range containers {
-		var name string
-		if len(container.Names) > 0 {
-			// Docker container names are prefixed with their parent's name (/ means docker
-			// daemon). See https://github.com/moby/moby/issues/6705#issuecomment-47298276.
-			name = strings.TrimPrefix(container.Names[0], "/")
-		} else {
-			name = container.ID
+		if !s.shouldScrape(&container, containerName(&container)) {
+			continue
 		}
-		labelValues := []*mpb.LabelValue{metricgenerator.MakeLabelValue(name)}
+		selected = append(selected, container)
+		if hasUsageStats(container.State) {
+			targets = append(targets, container)
+		}
+	}
+
+	if s.streamer != nil {
+		ids := make([]string, len(targets))
+		for i := range targets {
+			ids[i] = targets[i].ID
+		}
+		s.streamer.sync(ids)
+	}
 
+	// Each worker writes the metrics of a container to its own slot, so that
+	// the output order follows the container list whatever the scheduling.
+	results := make([][]*mpb.Metric, len(selected))
+	statsRead := make([]bool, len(selected))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.numWorkers(len(selected)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := &selected[i]
+				results[i], statsRead[i] = s.scrapeContainer(ctx, c, inspect, hasUsageStats(c.State))
+			}
+		}()
+	}
+	for i := range selected {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// In resource mode, every container has its own batch, and the metrics
+	// that are not about a single container are sent in a batch without
+	// resource.
+	var data []consumerdata.MetricsData
+	var metrics []*mpb.Metric
+	if s.resourcePerContainer {
+		for i := range selected {
+			c := &selected[i]
+			containerMetrics := append(results[i], s.containerStateMetric(c))
+			removeContainerName(containerMetrics)
+			data = append(data, consumerdata.MetricsData{
+				Resource: containerResource(c),
+				Metrics:  containerMetrics,
+			})
+		}
+	} else {
+		for _, r := range results {
+			metrics = append(metrics, r...)
+		}
+		for i := range selected {
+			metrics = append(metrics, s.containerStateMetric(&selected[i]))
+		}
+	}
+	if s.starts != nil {
+		ids := make(map[string]bool, len(targets))
+		for i := range targets {
+			ids[targets[i].ID] = true
+		}
+		s.starts.prune(ids)
+	}
+	if s.inspects != nil {
+		ids := make(map[string]bool, len(selected))
+		for i := range selected {
+			ids[selected[i].ID] = true
+		}
+		s.inspects.prune(ids)
+	}
+	if s.images != nil {
+		images := make(map[string]bool, len(selected))
+		for i := range selected {
+			images[containerImageRef(&selected[i])] = true
+		}
+		s.images.prune(images)
+	}
+	if s.events != nil {
+		names := make([]string, len(selected))
+		for i := range selected {
+			names[i] = containerName(&selected[i])
+		}
+		s.events.seen(names)
+		metrics = append(metrics, s.events.metrics()...)
+	}
+	numRead := 0
+	for _, ok := range statsRead {
+		if ok {
+			numRead++
+		}
+	}
+	metrics = append(metrics, s.self.metrics(s.now().Sub(start), numRead, s.startTime, s.now())...)
+	s.consume(ctx, append(data, consumerdata.MetricsData{Metrics: metrics}))
+}
+
+// consume sends the metrics to the consumer, retrying within the scrape
+// window. Dropped batches are counted and reported with the next batch.
+func (s *scraper) consume(ctx context.Context, data []consumerdata.MetricsData) {
+	err := consumeWithRetry(ctx, s.metricConsumer, pdatautil.MetricsFromMetricsData(data), s.logger)
+	if err != nil {
+		s.self.countDropped()
+		s.logger.Warn("Dropped docker metrics batch", zap.Int("batches", len(data)), zap.Error(err))
+	}
+}
+
+// requestTimeout returns the timeout of the docker API requests of a scrape.
+func (s *scraper) requestTimeout() time.Duration {
+	if s.timeout > 0 {
+		return s.timeout
+	}
+	return s.scrapeInterval
+}
+
+// listContainers lists all the containers from the docker API. In cgroup
+// mode, the request is given half the scrape timeout so that there is time
+// left to fall back to the cgroup filesystem.
+func (s *scraper) listContainers(ctx context.Context) ([]types.Container, error) {
+	if s.cgroups != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout()/2)
+		defer cancel()
+	}
+	return s.docker.ContainerList(ctx, types.ContainerListOptions{All: true})
+}
+
+// hasUsageStats returns whether a container in the given state, as reported
+// by the container list, is running and has usage stats. The state is empty
+// for daemons older than API 1.23.
+func hasUsageStats(state string) bool {
+	return state == "" || state == "running" || state == "paused"
+}
+
+// containerStateMetric reports the state of the container: 1 for its current
+// state, 0 for the others.
+func (s *scraper) containerStateMetric(c *types.Container) *mpb.Metric {
+	labelValues := s.containerLabelValues(c, containerName(c))
+	timeseries := make([]*mpb.TimeSeries, 0, len(containerStates))
+	for _, state := range containerStates {
+		var val int64
+		if state == c.State {
+			val = 1
+		}
+		lv := append(append([]*mpb.LabelValue{}, labelValues...), metricgenerator.MakeLabelValue(state))
+		timeseries = append(timeseries, metricgenerator.MakeInt64TimeSeries(val, s.startTime, s.now(), lv))
+	}
+	return &mpb.Metric{
+		MetricDescriptor: s.descriptor(containerStateDesc),
+		Timeseries:       timeseries,
+	}
+}
+
+// numWorkers returns the number of goroutines used to scrape n containers.
+func (s *scraper) numWorkers(n int) int {
+	w := s.maxConcurrentScrapes
+	if w < 1 {
+		w = 1
+	}
+	if w > n {
+		w = n
+	}
+	return w
+}
+
+// scrapeContainer reads the stats and info of a container and converts them
+// to metrics. The container info is not read if inspect is false, and the
+// usage stats are not read if running is false. It returns whether the usage
+// stats were read.
+func (s *scraper) scrapeContainer(ctx context.Context, container *types.Container, inspect, running bool) ([]*mpb.Metric, bool) {
+	name := containerName(container)
+	labelValues := s.containerLabelValues(container, name)
+
+	// The container info is read first, as its start time is the start of
+	// the cumulative usage stats series.
+	var metrics []*mpb.Metric
+	var info containerInfo
+	var err error
+	if inspect {
+		info, err = s.containerInfo(ctx, container)
+	}
+	if err != nil {
+		s.self.countError(callInspect)
+		glog.Warningf("readInfo failed for container %s(%s): %v", name, container.ID, err)
+	} else if inspect {
+		metrics = append(metrics, s.containerInfoToMetrics(info, running, labelValues)...)
+		if s.hostProcPath != "" && info.pid > 0 {
+			ps, err := readProcessStats(s.hostProcPath, info.pid)
+			if err != nil {
+				glog.Warningf("readProcessStats failed for container %s(%s): %v", name, container.ID, err)
+			} else {
+				metrics = append(metrics,
+					s.makeInt64Metric(processOpenFDsDesc, ps.openFDs, labelValues),
+					s.makeInt64Metric(processThreadsDesc, ps.threads, labelValues))
+			}
+		}
+		if s.images != nil {
+			if m, err := s.imageAgeMetric(ctx, container, labelValues); err != nil {
+				glog.Warningf("imageAge failed for container %s(%s): %v", name, container.ID, err)
+			} else {
+				metrics = append(metrics, m)
+			}
+		}
+		if s.diskUsage && info.logPath != "" {
+			size, err := logFileSize(info.logPath)
+			if err != nil {
+				glog.Warningf("logFileSize failed for container %s(%s): %v", name, container.ID, err)
+			} else {
+				metrics = append(metrics, s.makeInt64Metric(logFileSizeDesc, size, labelValues))
+			}
+		}
+	}
+
+	statsRead := false
+	if running {
 		stats, err := s.readResourceUsageStats(ctx, container.ID)
 		if err != nil {
+			s.self.countError(callStats)
 			glog.Warningf("readStats failed for container %s(%s): %v", name, container.ID, err)
 		} else {
-			metrics = append(metrics, s.usageStatsToMetrics(stats, labelValues)...)
+			usage := s.usageStatsToMetrics(stats, info.networkMode, labelValues)
+			setCumulativeStart(usage, s.cumulativeStart(container.ID, info.startedAt, stats))
+			metrics = append(metrics, usage...)
+			statsRead = true
 		}
+	}
 
-		info, err := s.readContainerInfo(ctx, container.ID)
-		if err != nil {
-			glog.Warningf("readInfo failed for container %s(%s): %v", name, container.ID, err)
-		} else {
-			metrics = append(metrics, s.containerInfoToMetrics(info, labelValues)...)
+	for _, m := range metrics {
+		m.MetricDescriptor = s.descriptor(m.MetricDescriptor)
+	}
+	return metrics, statsRead
+}
+
+// imageAgeMetric reports the age of the image of the container, as a
+// distribution with the same buckets as vm_image_ages. The image is added as
+// a label unless it is already one of the extra labels.
+func (s *scraper) imageAgeMetric(ctx context.Context, c *types.Container, labelValues []*mpb.LabelValue) (*mpb.Metric, error) {
+	created, err := s.images.get(ctx, s.docker, containerImageRef(c))
+	if err != nil {
+		return nil, err
+	}
+	age, err := imageAgeDays(created, s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	desc := imageAgeDesc
+	if !s.imageLabels {
+		desc = withLabelKeys(desc, imageLabel)
+		labelValues = append(append([]*mpb.LabelValue{}, labelValues...), metricgenerator.MakeLabelValue(c.Image))
+	}
+	return &mpb.Metric{
+		MetricDescriptor: desc,
+		Timeseries: []*mpb.TimeSeries{
+			metricgenerator.MakeSingleValueDistributionTimeSeries(age, s.startTime, s.now(), s.imageBucketOptions, labelValues),
+		},
+	}, nil
+}
+
+// containerImageRef returns the reference used to inspect the image of the
+// container: its ID, or its name for daemons that do not report the ID.
+func containerImageRef(c *types.Container) string {
+	if c.ImageID != "" {
+		return c.ImageID
+	}
+	return c.Image
+}
+
+// cumulativeStart returns the start timestamp of the cumulative usage series
+// of the container.
+func (s *scraper) cumulativeStart(id string, startedAt time.Time, stats *usageStats) time.Time {
+	if s.starts == nil {
+		return s.startTime
+	}
+	return s.starts.startTime(id, startedAt, countersFromStats(&stats.StatsJSON), s.now(), s.startTime)
+}
+
+// setCumulativeStart sets the start timestamp of the cumulative metrics.
+func setCumulativeStart(metrics []*mpb.Metric, start time.Time) {
+	ts := metricgenerator.TimeToTimestamp(start)
+	for _, m := range metrics {
+		switch m.MetricDescriptor.Type {
+		case mpb.MetricDescriptor_CUMULATIVE_INT64, mpb.MetricDescriptor_CUMULATIVE_DOUBLE, mpb.MetricDescriptor_CUMULATIVE_DISTRIBUTION:
+			for _, t := range m.Timeseries {
+				t.StartTimestamp = ts
+			}
 		}
 	}
+}
 
-	md := consumerdata.MetricsData{Metrics: metrics}
-	s.metricConsumer.ConsumeMetrics(ctx, pdatautil.MetricsFromMetricsData([]consumerdata.MetricsData{md}))
+// containerName returns the name of the container, or its ID if it has no name.
+func containerName(c *types.Container) string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	// Docker container names are prefixed with their parent's name (/ means docker
+	// daemon). See https://github.com/moby/moby/issues/6705#issuecomment-47298276.
+	return strings.TrimPrefix(c.Names[0], "/")
 }
 
-func (s *scraper) readResourceUsageStats(ctx context.Context, id string) (*types.StatsJSON, error) {
-	st, err := s.docker.ContainerStats(ctx, id, false /*stream*/)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve stats: %v", err)
+// makeExtraLabelKeys returns the label keys attached to every container metric
+// in addition to container_name.
+func makeExtraLabelKeys(imageLabels bool, containerLabels []string) ([]*mpb.LabelKey, error) {
+	var keys []*mpb.LabelKey
+	if imageLabels {
+		keys = append(keys, imageLabel, imageIDLabel)
+	}
+	seen := make(map[string]string)
+	for _, l := range containerLabels {
+		if l == "" {
+			return nil, fmt.Errorf("invalid container label: empty key")
+		}
+		key := "label_" + labelKeyReplacer.ReplaceAllString(l, "_")
+		if other, ok := seen[key]; ok {
+			return nil, fmt.Errorf("container labels %q and %q map to the same metric label %q", other, l, key)
+		}
+		seen[key] = l
+		keys = append(keys, &mpb.LabelKey{
+			Key:         key,
+			Description: fmt.Sprintf("Value of the %q docker label of the container", l),
+		})
 	}
-	defer st.Body.Close()
+	return keys, nil
+}
 
-	b, err := ioutil.ReadAll(st.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read stats: %v", err)
+var labelKeyReplacer = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// containerLabelValues returns the label values identifying the container,
+// matching container_name followed by s.extraLabelKeys.
+func (s *scraper) containerLabelValues(c *types.Container, name string) []*mpb.LabelValue {
+	labelValues := []*mpb.LabelValue{metricgenerator.MakeLabelValue(name)}
+	if s.imageLabels {
+		labelValues = append(labelValues, metricgenerator.MakeLabelValue(c.Image), metricgenerator.MakeLabelValue(c.ImageID))
+	}
+	for _, l := range s.containerLabels {
+		if v, ok := c.Labels[l]; ok {
+			labelValues = append(labelValues, metricgenerator.MakeLabelValue(v))
+		} else {
+			labelValues = append(labelValues, &mpb.LabelValue{})
+		}
+	}
+	return labelValues
+}
+
+// descriptor returns desc with the extra label keys of the scraper inserted
+// after container_name, which must be its first label key.
+func (s *scraper) descriptor(desc *mpb.MetricDescriptor) *mpb.MetricDescriptor {
+	if len(s.extraLabelKeys) == 0 {
+		return desc
 	}
+	d := *desc
+	d.LabelKeys = make([]*mpb.LabelKey, 0, len(desc.LabelKeys)+len(s.extraLabelKeys))
+	d.LabelKeys = append(d.LabelKeys, desc.LabelKeys[0])
+	d.LabelKeys = append(d.LabelKeys, s.extraLabelKeys...)
+	d.LabelKeys = append(d.LabelKeys, desc.LabelKeys[1:]...)
+	return &d
+}
 
-	var stats types.StatsJSON
-	if err = json.Unmarshal(b, &stats); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal stats JSON: %v", err)
+// shouldScrape returns whether the container passes the include and exclude
+// filters of the scraper.
+func (s *scraper) shouldScrape(c *types.Container, name string) bool {
+	if s.include != nil && !s.include.matches(c, name) {
+		return false
 	}
-	return &stats, nil
+	if s.exclude != nil && s.exclude.matches(c, name) {
+		return false
+	}
+	return true
 }
 
-func (s *scraper) usageStatsToMetrics(stats *types.StatsJSON, labelValues []*mpb.LabelValue) []*mpb.Metric {
-	var rx, tx uint64
-	for _, nw := range stats.Networks {
-		rx += nw.RxBytes
-		tx += nw.TxBytes
+func (s *scraper) readResourceUsageStats(ctx context.Context, id string) (*usageStats, error) {
+	if s.stats == nil {
+		return (&dockerStatsSource{docker: s.docker}).containerStats(ctx, id)
 	}
+	return s.stats.containerStats(ctx, id)
+}
 
-	return []*mpb.Metric{
+func (s *scraper) usageStatsToMetrics(stats *usageStats, networkMode container.NetworkMode, labelValues []*mpb.LabelValue) []*mpb.Metric {
+	metrics := []*mpb.Metric{
 		{
 			MetricDescriptor: memUsageDesc,
 			Timeseries: []*mpb.TimeSeries{
@@ -207,28 +1048,295 @@ func (s *scraper) usageStatsToMetrics(stats *types.StatsJSON, labelValues []*mpb
 			},
 		},
 		{
-			MetricDescriptor: nwRecvBytesDesc,
+			MetricDescriptor: cpuUsageTimeDesc,
 			Timeseries: []*mpb.TimeSeries{
-				metricgenerator.MakeInt64TimeSeries(int64(rx), s.startTime, s.now(), labelValues),
+				metricgenerator.MakeInt64TimeSeries(int64(stats.CPUStats.CPUUsage.TotalUsage), s.startTime, s.now(), labelValues),
 			},
 		},
 		{
-			MetricDescriptor: nwSentBytesDesc,
+			MetricDescriptor: cpuThrottledPeriodsDesc,
+			Timeseries: []*mpb.TimeSeries{
+				metricgenerator.MakeInt64TimeSeries(int64(stats.CPUStats.ThrottlingData.ThrottledPeriods), s.startTime, s.now(), labelValues),
+			},
+		},
+		{
+			MetricDescriptor: cpuThrottledTimeDesc,
+			Timeseries: []*mpb.TimeSeries{
+				metricgenerator.MakeInt64TimeSeries(int64(stats.CPUStats.ThrottlingData.ThrottledTime), s.startTime, s.now(), labelValues),
+			},
+		},
+	}
+
+	metrics = append(metrics, s.memoryStatsToMetrics(&stats.MemoryStats, labelValues)...)
+	metrics = append(metrics, s.makeInt64Metric(pidsCurrentDesc, int64(stats.PidsStats.Current), labelValues))
+	if stats.PidsStats.Limit > 0 {
+		metrics = append(metrics, s.makeInt64Metric(pidsLimitDesc, int64(stats.PidsStats.Limit), labelValues))
+	}
+	// The cgroup filesystem has no network stats.
+	if s.cgroups == nil && !(s.sharedNetwork == sharedNetworkSuppress && isSharedNetwork(networkMode)) {
+		metrics = append(metrics, s.networkStatsToMetrics(stats.Networks, networkMode, labelValues)...)
+	}
+	if utilization, ok := cpuUtilization(&stats.Stats, stats.onlineCPUs); ok {
+		metrics = append(metrics, &mpb.Metric{
+			MetricDescriptor: cpuUtilizationDesc,
 			Timeseries: []*mpb.TimeSeries{
-				metricgenerator.MakeInt64TimeSeries(int64(tx), s.startTime, s.now(), labelValues),
+				metricgenerator.MakeDoubleTimeSeries(utilization, s.startTime, s.now(), labelValues),
 			},
+		})
+	}
+	if m := s.blkioEntriesToMetric(blkioBytesDesc, stats.BlkioStats.IoServiceBytesRecursive, labelValues); m != nil {
+		metrics = append(metrics, m)
+	}
+	if m := s.blkioEntriesToMetric(blkioOperationsDesc, stats.BlkioStats.IoServicedRecursive, labelValues); m != nil {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// memoryStatsToMetrics converts the detailed memory stats of a container into
+// metrics. Breakdown metrics are only reported when the kernel exposes them.
+func (s *scraper) memoryStatsToMetrics(mem *types.MemoryStats, labelValues []*mpb.LabelValue) []*mpb.Metric {
+	metrics := []*mpb.Metric{
+		s.makeInt64Metric(memWorkingSetDesc, int64(memoryWorkingSet(mem)), labelValues),
+	}
+	// failcnt is only a cgroup v1 counter: docker omits it on cgroup v2.
+	if _, ok := memoryStat(mem.Stats, memV1Keys); ok {
+		metrics = append(metrics, s.makeInt64Metric(memFailcntDesc, int64(mem.Failcnt), labelValues))
+	}
+	for _, m := range []struct {
+		desc *mpb.MetricDescriptor
+		keys []string
+	}{
+		{memRSSDesc, memRSSKeys},
+		{memCacheDesc, memCacheKeys},
+		{memSwapDesc, memSwapKeys},
+	} {
+		if v, ok := memoryStat(mem.Stats, m.keys); ok {
+			metrics = append(metrics, s.makeInt64Metric(m.desc, int64(v), labelValues))
+		}
+	}
+	return metrics
+}
+
+// memoryWorkingSet returns the memory usage of the container without the
+// inactive page cache, which the kernel can reclaim under pressure.
+func memoryWorkingSet(mem *types.MemoryStats) uint64 {
+	inactive, _ := memoryStat(mem.Stats, memInactiveFileKeys)
+	if inactive > mem.Usage {
+		return 0
+	}
+	return mem.Usage - inactive
+}
+
+// memoryStat returns the value of the first of keys present in stats.
+func memoryStat(stats map[string]uint64, keys []string) (uint64, bool) {
+	for _, k := range keys {
+		if v, ok := stats[k]; ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// makeInt64Metric creates a metric with a single int64 timeseries.
+func (s *scraper) makeInt64Metric(desc *mpb.MetricDescriptor, val int64, labelValues []*mpb.LabelValue) *mpb.Metric {
+	return &mpb.Metric{
+		MetricDescriptor: desc,
+		Timeseries: []*mpb.TimeSeries{
+			metricgenerator.MakeInt64TimeSeries(val, s.startTime, s.now(), labelValues),
 		},
 	}
 }
 
+// isSharedNetwork returns whether a container with the given network mode
+// shares the network namespace of the host or of another container.
+func isSharedNetwork(mode container.NetworkMode) bool {
+	return mode.IsHost() || mode.IsContainer()
+}
+
+// networkModeValue returns the value of the network_mode label for a
+// container with the given network mode, empty if the mode is unknown.
+func networkModeValue(mode container.NetworkMode) string {
+	switch {
+	case mode == "":
+		return ""
+	case mode.IsHost():
+		return "host"
+	case mode.IsContainer():
+		return "container"
+	default:
+		return "private"
+	}
+}
+
+// networkStatsToMetrics converts the per-interface network stats of a
+// container into metrics. Interfaces are either reported separately, with an
+// interface label, or summed into a single timeseries per metric. The
+// network mode of the container is added as a label in the shared network
+// label mode.
+func (s *scraper) networkStatsToMetrics(networks map[string]types.NetworkStats, networkMode container.NetworkMode, labelValues []*mpb.LabelValue) []*mpb.Metric {
+	var labelKeys []*mpb.LabelKey
+	if s.sharedNetwork == sharedNetworkLabel {
+		labelKeys = append(labelKeys, networkModeLabel)
+		labelValues = append(append([]*mpb.LabelValue{}, labelValues...), metricgenerator.MakeLabelValue(networkModeValue(networkMode)))
+	}
+	if s.perInterfaceNetwork {
+		labelKeys = append(labelKeys, interfaceLabel)
+	}
+
+	type series struct {
+		labelValues []*mpb.LabelValue
+		stats       types.NetworkStats
+	}
+
+	var all []series
+	if s.perInterfaceNetwork {
+		names := make([]string, 0, len(networks))
+		for name := range networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			lv := append(append([]*mpb.LabelValue{}, labelValues...), metricgenerator.MakeLabelValue(name))
+			all = append(all, series{labelValues: lv, stats: networks[name]})
+		}
+	} else {
+		var total types.NetworkStats
+		for _, nw := range networks {
+			total.RxBytes += nw.RxBytes
+			total.TxBytes += nw.TxBytes
+			total.RxPackets += nw.RxPackets
+			total.TxPackets += nw.TxPackets
+			total.RxErrors += nw.RxErrors
+			total.TxErrors += nw.TxErrors
+			total.RxDropped += nw.RxDropped
+			total.TxDropped += nw.TxDropped
+		}
+		all = append(all, series{labelValues: labelValues, stats: total})
+	}
+
+	metrics := make([]*mpb.Metric, 0, len(networkMetrics))
+	for _, nm := range networkMetrics {
+		desc := nm.desc
+		if len(labelKeys) > 0 {
+			desc = withLabelKeys(desc, labelKeys...)
+		}
+		timeseries := make([]*mpb.TimeSeries, 0, len(all))
+		for _, sr := range all {
+			timeseries = append(timeseries, metricgenerator.MakeInt64TimeSeries(int64(nm.value(sr.stats)), s.startTime, s.now(), sr.labelValues))
+		}
+		metrics = append(metrics, &mpb.Metric{
+			MetricDescriptor: desc,
+			Timeseries:       timeseries,
+		})
+	}
+	return metrics
+}
+
+// withLabelKeys returns a copy of desc with keys appended to its label keys.
+func withLabelKeys(desc *mpb.MetricDescriptor, keys ...*mpb.LabelKey) *mpb.MetricDescriptor {
+	d := *desc
+	d.LabelKeys = append(append([]*mpb.LabelKey{}, desc.LabelKeys...), keys...)
+	return &d
+}
+
+// blkioEntriesToMetric converts the read and write entries of a blkio stats
+// list into one timeseries per device and operation. Other operations
+// reported by the kernel (sync, async, total...) are skipped. It returns nil
+// if there is no read or write entry.
+func (s *scraper) blkioEntriesToMetric(desc *mpb.MetricDescriptor, entries []types.BlkioStatEntry, labelValues []*mpb.LabelValue) *mpb.Metric {
+	var timeseries []*mpb.TimeSeries
+	for _, e := range entries {
+		op := strings.ToLower(e.Op)
+		if op != "read" && op != "write" {
+			continue
+		}
+		device := fmt.Sprintf("%d:%d", e.Major, e.Minor)
+		tsLabels := append(append([]*mpb.LabelValue{}, labelValues...),
+			metricgenerator.MakeLabelValue(device), metricgenerator.MakeLabelValue(op))
+		timeseries = append(timeseries, metricgenerator.MakeInt64TimeSeries(int64(e.Value), s.startTime, s.now(), tsLabels))
+	}
+	if len(timeseries) == 0 {
+		return nil
+	}
+	return &mpb.Metric{
+		MetricDescriptor: desc,
+		Timeseries:       timeseries,
+	}
+}
+
+// cpuUtilization computes the CPU usage of the container between the previous
+// and the current sample, the same way the docker CLI does. The number of
+// CPUs is onlineCPUs, or the length of the per-CPU usage for older daemons,
+// which is empty on cgroup v2. It returns false if the stats do not contain a
+// usable previous sample or the number of CPUs is unknown.
+func cpuUtilization(stats *types.Stats, onlineCPUs uint32) (float64, bool) {
+	cur, pre := stats.CPUStats, stats.PreCPUStats
+	if cur.SystemUsage <= pre.SystemUsage || cur.CPUUsage.TotalUsage < pre.CPUUsage.TotalUsage {
+		return 0, false
+	}
+	numCPUs := int(onlineCPUs)
+	if numCPUs == 0 {
+		numCPUs = len(cur.CPUUsage.PercpuUsage)
+	}
+	if numCPUs == 0 {
+		return 0, false
+	}
+	cpuDelta := float64(cur.CPUUsage.TotalUsage - pre.CPUUsage.TotalUsage)
+	systemDelta := float64(cur.SystemUsage - pre.SystemUsage)
+	return cpuDelta / systemDelta * float64(numCPUs) * 100, true
+}
+
+// containerInfo returns the info of the container, from the inspect cache if
+// it is enabled and still valid.
+func (s *scraper) containerInfo(ctx context.Context, container *types.Container) (containerInfo, error) {
+	if s.inspects == nil {
+		return s.readContainerInfo(ctx, container.ID)
+	}
+	if info, ok := s.inspects.get(container, s.now()); ok {
+		return info, nil
+	}
+	info, err := s.readContainerInfo(ctx, container.ID)
+	if err != nil {
+		return info, err
+	}
+	s.inspects.put(container, info, s.now())
+	return info, nil
+}
+
 func (s *scraper) readContainerInfo(ctx context.Context, id string) (containerInfo, error) {
 	var info containerInfo
 
-	c, err := s.docker.ContainerInspect(ctx, id)
+	var c types.ContainerJSON
+	var err error
+	if s.diskUsage {
+		c, _, err = s.docker.ContainerInspectWithRaw(ctx, id, true)
+	} else {
+		c, err = s.docker.ContainerInspect(ctx, id)
+	}
 	if err != nil {
 		return info, fmt.Errorf("failed to retrieve container info: %v", err)
 	}
+	info.sizeRw = c.SizeRw
+	info.sizeRootFs = c.SizeRootFs
+	info.logPath = c.LogPath
 	info.restartCount = int64(c.RestartCount)
+	info.pid = c.State.Pid
+	info.oomKilled = c.State.OOMKilled
+	info.exitCode = int64(c.State.ExitCode)
+	if h := c.State.Health; h != nil && h.Status != types.NoHealthcheck {
+		info.healthStatus = h.Status
+		info.failingStreak = int64(h.FailingStreak)
+	}
+	if hc := c.HostConfig; hc != nil {
+		info.cpuLimit = cpuLimit(hc.NanoCPUs, hc.CPUQuota, hc.CPUPeriod)
+		info.cpuShares = hc.CPUShares
+		info.memoryReservation = hc.MemoryReservation
+		if hc.PidsLimit > 0 {
+			info.pidsLimit = hc.PidsLimit
+		}
+		info.networkMode = hc.NetworkMode
+	}
 
 	t, err := time.Parse(time.RFC3339Nano, c.State.StartedAt)
 	if err != nil {
@@ -238,24 +1346,100 @@ func (s *scraper) readContainerInfo(ctx context.Context, id string) (containerIn
 	if t.After(now) {
 		return info, fmt.Errorf("invalid container start time %v, should be <= current time %v", t, now)
 	}
-	info.uptime = now.Sub(t)
+	info.startedAt = t
+	if created, err := time.Parse(time.RFC3339Nano, c.Created); err == nil {
+		info.createdAt = created
+	}
+	if finished, err := time.Parse(time.RFC3339Nano, c.State.FinishedAt); err == nil {
+		info.finishedAt = finished
+	}
 
 	return info, nil
 }
 
-func (s *scraper) containerInfoToMetrics(info containerInfo, labelValues []*mpb.LabelValue) []*mpb.Metric {
-	return []*mpb.Metric{
-		{
+// cpuLimit returns the number of cores a container may use, given either its
+// --cpus setting in billionths of a core or its CFS quota and period. It
+// returns 0 if the container is not limited.
+func cpuLimit(nanoCPUs, quota, period int64) float64 {
+	if nanoCPUs > 0 {
+		return float64(nanoCPUs) / 1e9
+	}
+	if quota > 0 && period > 0 {
+		return float64(quota) / float64(period)
+	}
+	return 0
+}
+
+// containerInfoToMetrics converts the info of a container into metrics. The
+// uptime is only reported if the container is running.
+func (s *scraper) containerInfoToMetrics(info containerInfo, running bool, labelValues []*mpb.LabelValue) []*mpb.Metric {
+	var oomKilled int64
+	if info.oomKilled {
+		oomKilled = 1
+	}
+	restartCountStart := info.createdAt
+	if restartCountStart.IsZero() {
+		restartCountStart = s.startTime
+	}
+
+	var metrics []*mpb.Metric
+	if running {
+		metrics = append(metrics, &mpb.Metric{
 			MetricDescriptor: uptimeDesc,
 			Timeseries: []*mpb.TimeSeries{
-				metricgenerator.MakeInt64TimeSeries(int64(info.uptime.Seconds()), s.startTime, s.now(), labelValues),
+				// The uptime is computed locally, as the info may be cached.
+				metricgenerator.MakeInt64TimeSeries(int64(s.now().Sub(info.startedAt).Seconds()), s.startTime, s.now(), labelValues),
 			},
-		},
+		})
+	}
+	metrics = append(metrics, []*mpb.Metric{
 		{
 			MetricDescriptor: restartCountDesc,
 			Timeseries: []*mpb.TimeSeries{
-				metricgenerator.MakeInt64TimeSeries(info.restartCount, s.startTime, s.now(), labelValues),
+				// Restarts are counted since the container was created.
+				metricgenerator.MakeInt64TimeSeries(info.restartCount, restartCountStart, s.now(), labelValues),
 			},
 		},
+		s.makeInt64Metric(oomKilledDesc, oomKilled, labelValues),
+		s.makeInt64Metric(exitCodeDesc, info.exitCode, labelValues),
+	}...)
+
+	if info.healthStatus != "" {
+		timeseries := make([]*mpb.TimeSeries, 0, len(healthStatuses))
+		for _, status := range healthStatuses {
+			var val int64
+			if status == info.healthStatus {
+				val = 1
+			}
+			lv := append(append([]*mpb.LabelValue{}, labelValues...), metricgenerator.MakeLabelValue(status))
+			timeseries = append(timeseries, metricgenerator.MakeInt64TimeSeries(val, s.startTime, s.now(), lv))
+		}
+		metrics = append(metrics,
+			&mpb.Metric{MetricDescriptor: healthStatusDesc, Timeseries: timeseries},
+			s.makeInt64Metric(healthFailingStreakDesc, info.failingStreak, labelValues))
+	}
+	if info.sizeRw != nil {
+		metrics = append(metrics, s.makeInt64Metric(diskRwDesc, *info.sizeRw, labelValues))
+	}
+	if info.sizeRootFs != nil {
+		metrics = append(metrics, s.makeInt64Metric(diskRootFsDesc, *info.sizeRootFs, labelValues))
+	}
+	if info.cpuLimit > 0 {
+		metrics = append(metrics, &mpb.Metric{
+			MetricDescriptor: cpuLimitDesc,
+			Timeseries: []*mpb.TimeSeries{
+				metricgenerator.MakeDoubleTimeSeries(info.cpuLimit, s.startTime, s.now(), labelValues),
+			},
+		})
+	}
+	if info.cpuShares > 0 {
+		metrics = append(metrics, s.makeInt64Metric(cpuSharesDesc, info.cpuShares, labelValues))
+	}
+	if info.memoryReservation > 0 {
+		metrics = append(metrics, s.makeInt64Metric(memReservationDesc, info.memoryReservation, labelValues))
+	}
+	if info.pidsLimit > 0 {
+		metrics = append(metrics, s.makeInt64Metric(pidsConfiguredLimitDesc, info.pidsLimit, labelValues))
 	}
+	return metrics
 }
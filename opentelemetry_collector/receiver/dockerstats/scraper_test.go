@@ -6,12 +6,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"go.opentelemetry.io/collector/consumer/consumerdata"
 	"go.opentelemetry.io/collector/consumer/pdata"
@@ -22,13 +27,24 @@ import (
 
 type fakeDocker struct {
 	client.Client
+
+	mu            sync.Mutex
+	imageInspects int
+	inspects      int
+	// statuses overrides the status of the listed containers by ID.
+	statuses map[string]string
+	// startedAts overrides the start time of the inspected containers by ID.
+	startedAts map[string]string
 }
 
 func (d *fakeDocker) ContainerList(ctx context.Context, opts types.ContainerListOptions) ([]types.Container, error) {
-	return []types.Container{
+	containers := []types.Container{
 		{
-			ID:    "id1",
-			Names: []string{"name1a", "name1b"},
+			ID:      "id1",
+			Names:   []string{"name1a", "name1b"},
+			Image:   "app:v1",
+			ImageID: "sha256:aaa",
+			Labels:  map[string]string{"role": "app", "com.example/version": "42"},
 		},
 		{
 			ID:    "id2",
@@ -37,16 +53,78 @@ func (d *fakeDocker) ContainerList(ctx context.Context, opts types.ContainerList
 		{
 			ID:    "id3",
 			Names: []string{"name3"},
+			State: "running",
 		},
-	}, nil
+	}
+	if opts.All {
+		containers = append(containers, types.Container{
+			ID:    "id4",
+			Names: []string{"/fluentd"},
+			State: "exited",
+		})
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range containers {
+		if status, ok := d.statuses[containers[i].ID]; ok {
+			containers[i].Status = status
+		}
+	}
+	return containers, nil
 }
 
 func (d *fakeDocker) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
 	s1 := types.StatsJSON{
 		Stats: types.Stats{
+			PidsStats: types.PidsStats{
+				Current: 12,
+				Limit:   100,
+			},
 			MemoryStats: types.MemoryStats{
-				Usage: 33,
-				Limit: 66,
+				Usage:   33,
+				Limit:   66,
+				Failcnt: 2,
+				Stats: map[string]uint64{
+					"rss":                 10,
+					"total_rss":           12,
+					"cache":               20,
+					"total_cache":         21,
+					"total_swap":          3,
+					"total_inactive_file": 8,
+				},
+			},
+			CPUStats: types.CPUStats{
+				CPUUsage: types.CPUUsage{
+					TotalUsage:  5000,
+					PercpuUsage: []uint64{3000, 2000},
+				},
+				SystemUsage: 20000,
+				ThrottlingData: types.ThrottlingData{
+					Periods:          10,
+					ThrottledPeriods: 4,
+					ThrottledTime:    1200,
+				},
+			},
+			PreCPUStats: types.CPUStats{
+				CPUUsage: types.CPUUsage{
+					TotalUsage:  4000,
+					PercpuUsage: []uint64{2500, 1500},
+				},
+				SystemUsage: 16000,
+			},
+			BlkioStats: types.BlkioStats{
+				IoServiceBytesRecursive: []types.BlkioStatEntry{
+					{Major: 8, Minor: 0, Op: "Read", Value: 4096},
+					{Major: 8, Minor: 0, Op: "Write", Value: 8192},
+					{Major: 8, Minor: 0, Op: "Sync", Value: 12288},
+					{Major: 8, Minor: 0, Op: "Total", Value: 12288},
+					{Major: 8, Minor: 16, Op: "Read", Value: 512},
+				},
+				IoServicedRecursive: []types.BlkioStatEntry{
+					{Major: 8, Minor: 0, Op: "Read", Value: 1},
+					{Major: 8, Minor: 0, Op: "Write", Value: 2},
+					{Major: 8, Minor: 0, Op: "Total", Value: 3},
+				},
 			},
 		},
 		Networks: map[string]types.NetworkStats{
@@ -61,16 +139,36 @@ func (d *fakeDocker) ContainerStats(ctx context.Context, id string, stream bool)
 			MemoryStats: types.MemoryStats{
 				Usage: 44,
 				Limit: 88,
+				// cgroup v2 key names.
+				Stats: map[string]uint64{
+					"anon":          15,
+					"file":          25,
+					"inactive_file": 4,
+				},
+			},
+			CPUStats: types.CPUStats{
+				CPUUsage: types.CPUUsage{
+					TotalUsage: 7000,
+				},
+				SystemUsage: 30000,
 			},
 		},
 		Networks: map[string]types.NetworkStats{
 			"eth0": {
-				RxBytes: 333,
-				TxBytes: 444,
+				RxBytes:   333,
+				TxBytes:   444,
+				RxPackets: 10,
+				TxPackets: 20,
+				RxErrors:  1,
+				RxDropped: 2,
 			},
 			"eth1": {
-				RxBytes: 222,
-				TxBytes: 333,
+				RxBytes:   222,
+				TxBytes:   333,
+				RxPackets: 5,
+				TxPackets: 6,
+				TxErrors:  3,
+				TxDropped: 4,
 			},
 		},
 	}
@@ -99,6 +197,11 @@ func (d *fakeDocker) ContainerStats(ctx context.Context, id string, stream bool)
 }
 
 func (d *fakeDocker) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	d.mu.Lock()
+	d.inspects++
+	startedAt, restarted := d.startedAts[id]
+	d.mu.Unlock()
+
 	var c types.ContainerJSON
 	var err error
 
@@ -106,9 +209,25 @@ func (d *fakeDocker) ContainerInspect(ctx context.Context, id string) (types.Con
 	case "id1":
 		c = types.ContainerJSON{
 			ContainerJSONBase: &types.ContainerJSONBase{
+				Created:      "2019-12-01T00:00:00.000000000Z",
 				RestartCount: 3,
 				State: &types.ContainerState{
 					StartedAt: "2019-12-31T12:00:00.000000000Z",
+					Pid:       1234,
+					OOMKilled: true,
+					ExitCode:  137,
+					Health: &types.Health{
+						Status:        types.Unhealthy,
+						FailingStreak: 4,
+					},
+				},
+				HostConfig: &container.HostConfig{
+					Resources: container.Resources{
+						NanoCPUs:  1500000000,
+						CPUShares: 512,
+						PidsLimit: 200,
+					},
+					NetworkMode: "bridge",
 				},
 			},
 		}
@@ -119,16 +238,63 @@ func (d *fakeDocker) ContainerInspect(ctx context.Context, id string) (types.Con
 				State: &types.ContainerState{
 					StartedAt: "2019-12-31T00:00:00.000000000Z",
 				},
+				HostConfig: &container.HostConfig{
+					Resources: container.Resources{
+						CPUQuota:          50000,
+						CPUPeriod:         100000,
+						MemoryReservation: 64 << 20,
+						PidsLimit:         -1,
+					},
+					NetworkMode: "host",
+				},
 			},
 		}
 	case "id3":
 		c = types.ContainerJSON{}
 		err = fmt.Errorf("manual error")
+	case "id4":
+		c = types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				RestartCount: 1,
+				State: &types.ContainerState{
+					Status:     "exited",
+					StartedAt:  "2019-12-31T00:00:00.000000000Z",
+					FinishedAt: "2019-12-31T23:00:00.000000000Z",
+					OOMKilled:  true,
+					ExitCode:   137,
+				},
+			},
+		}
 	}
 
+	if restarted && c.ContainerJSONBase != nil {
+		c.State.StartedAt = startedAt
+		c.RestartCount++
+	}
 	return c, err
 }
 
+func (d *fakeDocker) ContainerInspectWithRaw(ctx context.Context, id string, getSize bool) (types.ContainerJSON, []byte, error) {
+	c, err := d.ContainerInspect(ctx, id)
+	if err == nil && getSize && id == "id1" {
+		sizeRw, sizeRootFs := int64(4096), int64(123456789)
+		c.SizeRw = &sizeRw
+		c.SizeRootFs = &sizeRootFs
+		c.LogPath = path.Join("testdata", "logs", "id1", "id1-json.log")
+	}
+	return c, nil, err
+}
+
+func (d *fakeDocker) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	d.mu.Lock()
+	d.imageInspects++
+	d.mu.Unlock()
+	if image != "sha256:aaa" {
+		return types.ImageInspect{}, nil, fmt.Errorf("no such image: %q", image)
+	}
+	return types.ImageInspect{ID: image, Created: "2019-12-01T00:00:00.000000000Z"}, nil, nil
+}
+
 // fakeMetricConsumer extends consumer.MetricsConsumer.
 type fakeMetricsConsumer struct {
 	metrics pdata.Metrics
@@ -154,27 +320,331 @@ func TestScraperExport(t *testing.T) {
 		now:            fakeNow,
 	}
 
-	s.export()
+	s.export(context.Background())
 
 	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
 	verifyContainerMetricValue(t, data, "container/memory/usage", "name1a", 33)
 	verifyContainerMetricValue(t, data, "container/memory/limit", "name1a", 66)
+	verifyContainerMetricValue(t, data, "container/memory/rss", "name1a", 12)
+	verifyContainerMetricValue(t, data, "container/memory/cache", "name1a", 21)
+	verifyContainerMetricValue(t, data, "container/memory/swap", "name1a", 3)
+	verifyContainerMetricValue(t, data, "container/memory/failcnt", "name1a", 2)
+	verifyContainerMetricValue(t, data, "container/memory/working_set", "name1a", 25)
 	verifyContainerMetricValue(t, data, "container/network/received_bytes", "name1a", 111)
 	verifyContainerMetricValue(t, data, "container/network/sent_bytes", "name1a", 222)
+	verifyContainerMetricValue(t, data, "container/cpu/usage_time", "name1a", 5000)
+	verifyContainerMetricValue(t, data, "container/cpu/throttled_periods", "name1a", 4)
+	verifyContainerMetricValue(t, data, "container/cpu/throttled_time", "name1a", 1200)
+	verifyContainerMetricDoubleValue(t, data, "container/cpu/utilization", "name1a", 50)
+	verifyTimeSeriesValue(t, data, "container/blkio/bytes", []string{"name1a", "8:0", "read"}, 4096)
+	verifyTimeSeriesValue(t, data, "container/blkio/bytes", []string{"name1a", "8:0", "write"}, 8192)
+	verifyTimeSeriesValue(t, data, "container/blkio/bytes", []string{"name1a", "8:16", "read"}, 512)
+	verifyTimeSeriesAbsent(t, data, "container/blkio/bytes", []string{"name1a", "8:0", "total"})
+	verifyTimeSeriesValue(t, data, "container/blkio/operations", []string{"name1a", "8:0", "read"}, 1)
+	verifyTimeSeriesValue(t, data, "container/blkio/operations", []string{"name1a", "8:0", "write"}, 2)
+	verifyContainerMetricValue(t, data, "container/pids/current", "name1a", 12)
+	verifyContainerMetricValue(t, data, "container/pids/limit", "name1a", 100)
+	verifyContainerMetricAbsent(t, data, "container/process/open_fds", "name1a")
 	verifyContainerMetricValue(t, data, "container/uptime", "name1a", 43200)
 	verifyContainerMetricValue(t, data, "container/restart_count", "name1a", 3)
+	verifyContainerMetricValue(t, data, "container/oom_killed", "name1a", 1)
+	verifyContainerMetricValue(t, data, "container/exit_code", "name1a", 137)
+	verifyTimeSeriesValue(t, data, "container/health/status", []string{"name1a", "unhealthy"}, 1)
+	verifyTimeSeriesValue(t, data, "container/health/status", []string{"name1a", "healthy"}, 0)
+	verifyTimeSeriesValue(t, data, "container/health/status", []string{"name1a", "starting"}, 0)
+	verifyContainerMetricValue(t, data, "container/health/failing_streak", "name1a", 4)
+	verifyContainerMetricDoubleValue(t, data, "container/cpu/limit", "name1a", 1.5)
+	verifyContainerMetricValue(t, data, "container/cpu/shares", "name1a", 512)
+	verifyContainerMetricAbsent(t, data, "container/memory/reservation", "name1a")
+	verifyContainerMetricValue(t, data, "container/pids/configured_limit", "name1a", 200)
+	verifyContainerMetricDoubleValue(t, data, "container/cpu/limit", "id2", 0.5)
+	verifyContainerMetricAbsent(t, data, "container/cpu/shares", "id2")
+	verifyContainerMetricValue(t, data, "container/memory/reservation", "id2", 64<<20)
+	verifyContainerMetricAbsent(t, data, "container/pids/configured_limit", "id2")
 	verifyContainerMetricValue(t, data, "container/memory/usage", "id2", 44)
 	verifyContainerMetricValue(t, data, "container/memory/limit", "id2", 88)
+	verifyContainerMetricValue(t, data, "container/memory/rss", "id2", 15)
+	verifyContainerMetricValue(t, data, "container/memory/cache", "id2", 25)
+	verifyContainerMetricAbsent(t, data, "container/memory/swap", "id2")
+	// There is no failcnt in cgroup v2.
+	verifyContainerMetricAbsent(t, data, "container/memory/failcnt", "id2")
+	verifyContainerMetricValue(t, data, "container/memory/working_set", "id2", 40)
 	verifyContainerMetricValue(t, data, "container/network/received_bytes", "id2", 555)
 	verifyContainerMetricValue(t, data, "container/network/sent_bytes", "id2", 777)
+	verifyContainerMetricValue(t, data, "container/network/received_packets", "id2", 15)
+	verifyContainerMetricValue(t, data, "container/network/sent_packets", "id2", 26)
+	verifyContainerMetricValue(t, data, "container/network/received_errors", "id2", 1)
+	verifyContainerMetricValue(t, data, "container/network/sent_errors", "id2", 3)
+	verifyContainerMetricValue(t, data, "container/network/received_dropped", "id2", 2)
+	verifyContainerMetricValue(t, data, "container/network/sent_dropped", "id2", 4)
+	verifyContainerMetricValue(t, data, "container/cpu/usage_time", "id2", 7000)
+	verifyContainerMetricValue(t, data, "container/cpu/throttled_periods", "id2", 0)
+	verifyContainerMetricValue(t, data, "container/cpu/throttled_time", "id2", 0)
+	verifyContainerMetricAbsent(t, data, "container/blkio/bytes", "id2")
+	verifyContainerMetricValue(t, data, "container/pids/current", "id2", 0)
+	verifyContainerMetricAbsent(t, data, "container/pids/limit", "id2")
 	verifyContainerMetricValue(t, data, "container/uptime", "id2", 86400)
 	verifyContainerMetricValue(t, data, "container/restart_count", "id2", 5)
+	verifyContainerMetricValue(t, data, "container/oom_killed", "id2", 0)
+	verifyContainerMetricValue(t, data, "container/exit_code", "id2", 0)
+	verifyContainerMetricAbsent(t, data, "container/health/status", "id2")
+	verifyContainerMetricAbsent(t, data, "container/health/failing_streak", "id2")
 	verifyContainerMetricAbsent(t, data, "container/memory/usage", "name3")
 	verifyContainerMetricAbsent(t, data, "container/memory/limit", "name3")
 	verifyContainerMetricAbsent(t, data, "container/network/received_bytes", "name3")
 	verifyContainerMetricAbsent(t, data, "container/network/sent_bytes", "name3")
+	verifyContainerMetricAbsent(t, data, "container/cpu/usage_time", "name3")
 	verifyContainerMetricAbsent(t, data, "container/uptime", "name3")
 	verifyContainerMetricAbsent(t, data, "container/restart_count", "name3")
+	verifyTimeSeriesValue(t, data, "container/state", []string{"name3", "running"}, 1)
+	verifyTimeSeriesValue(t, data, "container/state", []string{"name3", "exited"}, 0)
+	verifyTimeSeriesValue(t, data, "container/state", []string{"fluentd", "exited"}, 1)
+	verifyTimeSeriesValue(t, data, "container/state", []string{"fluentd", "running"}, 0)
+	// Stopped containers are inspected, but have no usage stats.
+	verifyContainerMetricValue(t, data, "container/exit_code", "fluentd", 137)
+	verifyContainerMetricValue(t, data, "container/oom_killed", "fluentd", 1)
+	verifyContainerMetricValue(t, data, "container/restart_count", "fluentd", 1)
+	verifyContainerMetricAbsent(t, data, "container/uptime", "fluentd")
+	verifyContainerMetricAbsent(t, data, "container/memory/usage", "fluentd")
+	verifyContainerMetricAbsent(t, data, "container/cpu/usage_time", "fluentd")
+}
+
+func TestScraperExportPerInterfaceNetwork(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:           fakeNow(),
+		metricConsumer:      c,
+		docker:              &fakeDocker{},
+		scrapeInterval:      10 * time.Second,
+		perInterfaceNetwork: true,
+		now:                 fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyTimeSeriesValue(t, data, "container/network/received_bytes", []string{"name1a", "eth0"}, 111)
+	verifyTimeSeriesValue(t, data, "container/network/sent_bytes", []string{"name1a", "eth0"}, 222)
+	verifyTimeSeriesValue(t, data, "container/network/received_bytes", []string{"id2", "eth0"}, 333)
+	verifyTimeSeriesValue(t, data, "container/network/received_bytes", []string{"id2", "eth1"}, 222)
+	verifyTimeSeriesValue(t, data, "container/network/sent_packets", []string{"id2", "eth0"}, 20)
+	verifyTimeSeriesValue(t, data, "container/network/sent_packets", []string{"id2", "eth1"}, 6)
+	verifyTimeSeriesValue(t, data, "container/network/received_errors", []string{"id2", "eth0"}, 1)
+	verifyTimeSeriesValue(t, data, "container/network/sent_dropped", []string{"id2", "eth1"}, 4)
+	verifyTimeSeriesAbsent(t, data, "container/network/received_bytes", []string{"id2"})
+	for _, m := range data.Metrics {
+		if m.MetricDescriptor.Name == "container/network/received_bytes" {
+			assert.Equal(t, []*mpb.LabelKey{containerNameLabel, interfaceLabel}, m.MetricDescriptor.LabelKeys)
+		}
+	}
+}
+
+func TestScraperExportSharedNetworkLabel(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:           fakeNow(),
+		metricConsumer:      c,
+		docker:              &fakeDocker{},
+		scrapeInterval:      10 * time.Second,
+		perInterfaceNetwork: true,
+		sharedNetwork:       sharedNetworkLabel,
+		now:                 fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyTimeSeriesValue(t, data, "container/network/received_bytes", []string{"name1a", "private", "eth0"}, 111)
+	verifyTimeSeriesValue(t, data, "container/network/received_bytes", []string{"id2", "host", "eth0"}, 333)
+	verifyTimeSeriesValue(t, data, "container/network/received_bytes", []string{"id2", "host", "eth1"}, 222)
+	verifyContainerMetricValue(t, data, "container/memory/usage", "id2", 44)
+	for _, m := range data.Metrics {
+		if m.MetricDescriptor.Name == "container/network/received_bytes" {
+			assert.Equal(t, []*mpb.LabelKey{containerNameLabel, networkModeLabel, interfaceLabel}, m.MetricDescriptor.LabelKeys)
+		}
+	}
+	assert.Equal(t, []*mpb.LabelKey{containerNameLabel}, networkMetrics[0].desc.LabelKeys)
+}
+
+func TestScraperExportSharedNetworkSuppress(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &fakeDocker{},
+		scrapeInterval: 10 * time.Second,
+		sharedNetwork:  sharedNetworkSuppress,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/network/received_bytes", "name1a", 111)
+	verifyContainerMetricAbsent(t, data, "container/network/received_bytes", "id2")
+	verifyContainerMetricAbsent(t, data, "container/network/sent_packets", "id2")
+	verifyContainerMetricValue(t, data, "container/memory/usage", "id2", 44)
+}
+
+func TestNetworkModeValue(t *testing.T) {
+	assert.Equal(t, "", networkModeValue(""))
+	assert.Equal(t, "host", networkModeValue("host"))
+	assert.Equal(t, "container", networkModeValue("container:abc123"))
+	assert.Equal(t, "private", networkModeValue("bridge"))
+	assert.Equal(t, "private", networkModeValue("default"))
+	assert.Equal(t, "private", networkModeValue("my-network"))
+	assert.True(t, isSharedNetwork("container:abc123"))
+	assert.False(t, isSharedNetwork("none"))
+}
+
+func TestScraperExportProcessStats(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &fakeDocker{},
+		scrapeInterval: 10 * time.Second,
+		hostProcPath:   path.Join("testdata", "proc"),
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/process/open_fds", "name1a", 4)
+	verifyContainerMetricValue(t, data, "container/process/threads", "name1a", 7)
+	// id2 has no running process.
+	verifyContainerMetricAbsent(t, data, "container/process/open_fds", "id2")
+}
+
+func TestScraperExportDiskUsage(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &fakeDocker{},
+		scrapeInterval: 10 * time.Second,
+		diskUsage:      true,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/disk/rw_bytes", "name1a", 4096)
+	verifyContainerMetricValue(t, data, "container/disk/rootfs_bytes", "name1a", 123456789)
+	// The log file and its two rotated files.
+	verifyContainerMetricValue(t, data, "container/log/file_bytes", "name1a", 550)
+	verifyContainerMetricValue(t, data, "container/restart_count", "name1a", 3)
+	// The daemon did not report the sizes of id2.
+	verifyContainerMetricAbsent(t, data, "container/disk/rw_bytes", "id2")
+	verifyContainerMetricAbsent(t, data, "container/log/file_bytes", "id2")
+}
+
+func TestScraperExportNoDiskUsageByDefault(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &fakeDocker{},
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricAbsent(t, data, "container/disk/rw_bytes", "name1a")
+	verifyContainerMetricAbsent(t, data, "container/log/file_bytes", "name1a")
+}
+
+func TestLogFileSizeMissing(t *testing.T) {
+	_, err := logFileSize(path.Join("testdata", "logs", "missing", "missing-json.log"))
+	assert.Error(t, err)
+}
+
+func TestReadProcessStatsErrors(t *testing.T) {
+	_, err := readProcessStats(path.Join("testdata", "proc"), 999)
+	assert.Error(t, err)
+}
+
+func TestScraperExportFiltered(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &fakeDocker{},
+		scrapeInterval: 10 * time.Second,
+		include:        &containerFilter{labels: map[string]string{"role": ""}, images: map[string]bool{}},
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/memory/usage", "name1a", 33)
+	verifyContainerMetricAbsent(t, data, "container/memory/usage", "id2")
+
+	s.include = nil
+	s.exclude = &containerFilter{images: map[string]bool{"app:v1": true}}
+	s.export(context.Background())
+
+	data = pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricAbsent(t, data, "container/memory/usage", "name1a")
+	verifyContainerMetricValue(t, data, "container/memory/usage", "id2", 44)
+}
+
+func TestMakeExtraLabelKeys(t *testing.T) {
+	keys, err := makeExtraLabelKeys(false, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	keys, err = makeExtraLabelKeys(true, []string{"role", "com.example/version"})
+	assert.NoError(t, err)
+	var names []string
+	for _, k := range keys {
+		names = append(names, k.Key)
+	}
+	assert.Equal(t, []string{"image", "image_id", "label_role", "label_com_example_version"}, names)
+
+	_, err = makeExtraLabelKeys(false, []string{""})
+	assert.Error(t, err)
+	_, err = makeExtraLabelKeys(false, []string{"a.b", "a-b"})
+	assert.Error(t, err)
+}
+
+func TestScraperExportContainerLabels(t *testing.T) {
+	labels := []string{"role", "com.example/version"}
+	extraLabelKeys, err := makeExtraLabelKeys(true, labels)
+	assert.NoError(t, err)
+
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:       fakeNow(),
+		metricConsumer:  c,
+		docker:          &fakeDocker{},
+		scrapeInterval:  10 * time.Second,
+		containerLabels: labels,
+		imageLabels:     true,
+		extraLabelKeys:  extraLabelKeys,
+		now:             fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyTimeSeriesValue(t, data, "container/memory/usage", []string{"name1a", "app:v1", "sha256:aaa", "app", "42"}, 33)
+	verifyTimeSeriesValue(t, data, "container/blkio/bytes", []string{"name1a", "app:v1", "sha256:aaa", "app", "42", "8:0", "read"}, 4096)
+	verifyTimeSeriesValue(t, data, "container/memory/usage", []string{"id2", "", "", "", ""}, 44)
+	for _, m := range data.Metrics {
+		assert.Equal(t, len(m.MetricDescriptor.LabelKeys), len(m.Timeseries[0].LabelValues), m.MetricDescriptor.Name)
+		if !strings.HasPrefix(m.MetricDescriptor.Name, "container/") {
+			continue
+		}
+		assert.Equal(t, "label_com_example_version", m.MetricDescriptor.LabelKeys[4].Key)
+	}
+	// Package level descriptors must not be modified.
+	assert.Equal(t, []*mpb.LabelKey{containerNameLabel}, memUsageDesc.LabelKeys)
 }
 
 func verifyContainerMetricValue(t *testing.T, data consumerdata.MetricsData, name, label string, value int64) {
@@ -191,6 +661,20 @@ func verifyContainerMetricValue(t *testing.T, data consumerdata.MetricsData, nam
 	assert.Equal(t, value, metric.Timeseries[0].Points[0].GetInt64Value())
 }
 
+func verifyContainerMetricDoubleValue(t *testing.T, data consumerdata.MetricsData, name, label string, value float64) {
+	var metric *mpb.Metric
+	for _, m := range data.Metrics {
+		if m.MetricDescriptor.Name == name && m.Timeseries[0].LabelValues[0].Value == label {
+			metric = m
+		}
+	}
+	if metric == nil {
+		t.Errorf("Unable to find metric %q", name)
+		return
+	}
+	assert.InDelta(t, value, metric.Timeseries[0].Points[0].GetDoubleValue(), 1e-9)
+}
+
 func verifyContainerMetricAbsent(t *testing.T, data consumerdata.MetricsData, name, label string) {
 	for _, m := range data.Metrics {
 		if m.MetricDescriptor.Name == name && m.Timeseries[0].LabelValues[0].Value == label {
@@ -200,6 +684,240 @@ func verifyContainerMetricAbsent(t *testing.T, data consumerdata.MetricsData, na
 	}
 }
 
+func TestMemoryWorkingSet(t *testing.T) {
+	assert.Equal(t, uint64(100), memoryWorkingSet(&types.MemoryStats{Usage: 100}))
+	assert.Equal(t, uint64(70), memoryWorkingSet(&types.MemoryStats{
+		Usage: 100,
+		Stats: map[string]uint64{"total_inactive_file": 30, "inactive_file": 10},
+	}))
+	assert.Equal(t, uint64(90), memoryWorkingSet(&types.MemoryStats{
+		Usage: 100,
+		Stats: map[string]uint64{"inactive_file": 10},
+	}))
+	assert.Equal(t, uint64(0), memoryWorkingSet(&types.MemoryStats{
+		Usage: 10,
+		Stats: map[string]uint64{"inactive_file": 30},
+	}))
+}
+
+func TestCPUUtilization(t *testing.T) {
+	tests := []struct {
+		name       string
+		stats      types.Stats
+		onlineCPUs uint32
+		want       float64
+		wantOK     bool
+	}{
+		{
+			name: "two cores",
+			stats: types.Stats{
+				CPUStats: types.CPUStats{
+					CPUUsage:    types.CPUUsage{TotalUsage: 300, PercpuUsage: []uint64{150, 150}},
+					SystemUsage: 1000,
+				},
+				PreCPUStats: types.CPUStats{
+					CPUUsage:    types.CPUUsage{TotalUsage: 100},
+					SystemUsage: 600,
+				},
+			},
+			want:   100,
+			wantOK: true,
+		},
+		{
+			name: "online cpus without per-cpu usage",
+			stats: types.Stats{
+				CPUStats: types.CPUStats{
+					CPUUsage:    types.CPUUsage{TotalUsage: 200},
+					SystemUsage: 1000,
+				},
+			},
+			onlineCPUs: 4,
+			want:       80,
+			wantOK:     true,
+		},
+		{
+			name: "online cpus over per-cpu usage",
+			stats: types.Stats{
+				CPUStats: types.CPUStats{
+					CPUUsage:    types.CPUUsage{TotalUsage: 300, PercpuUsage: []uint64{150, 150, 0, 0}},
+					SystemUsage: 1000,
+				},
+				PreCPUStats: types.CPUStats{
+					CPUUsage:    types.CPUUsage{TotalUsage: 100},
+					SystemUsage: 600,
+				},
+			},
+			onlineCPUs: 2,
+			want:       100,
+			wantOK:     true,
+		},
+		{
+			name: "unknown number of cpus",
+			stats: types.Stats{
+				CPUStats: types.CPUStats{
+					CPUUsage:    types.CPUUsage{TotalUsage: 200},
+					SystemUsage: 1000,
+				},
+			},
+			wantOK: false,
+		},
+		{
+			name: "no system delta",
+			stats: types.Stats{
+				CPUStats: types.CPUStats{
+					CPUUsage:    types.CPUUsage{TotalUsage: 200},
+					SystemUsage: 1000,
+				},
+				PreCPUStats: types.CPUStats{
+					CPUUsage:    types.CPUUsage{TotalUsage: 100},
+					SystemUsage: 1000,
+				},
+			},
+			wantOK: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := cpuUtilization(&tc.stats, tc.onlineCPUs)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.InDelta(t, tc.want, got, 1e-9)
+		})
+	}
+}
+
+func TestDecodeUsageStats(t *testing.T) {
+	stats, err := decodeUsageStats([]byte(`{"id":"id1","cpu_stats":{"cpu_usage":{"total_usage":200},"system_cpu_usage":1000,"online_cpus":8},"memory_stats":{"usage":33}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "id1", stats.ID)
+	assert.Equal(t, uint64(200), stats.CPUStats.CPUUsage.TotalUsage)
+	assert.Equal(t, uint64(33), stats.MemoryStats.Usage)
+	assert.Equal(t, uint32(8), stats.onlineCPUs)
+
+	// Daemons older than API 1.27 do not report online_cpus.
+	stats, err = decodeUsageStats([]byte(`{"cpu_stats":{"cpu_usage":{"total_usage":200}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), stats.onlineCPUs)
+
+	_, err = decodeUsageStats([]byte(`{"cpu_stats":`))
+	assert.Error(t, err)
+}
+
+// findTimeSeries returns the timeseries of metric name whose label values
+// are exactly labels, or nil if there is none.
+func findTimeSeries(data consumerdata.MetricsData, name string, labels []string) *mpb.TimeSeries {
+	for _, m := range data.Metrics {
+		if m.MetricDescriptor.Name != name {
+			continue
+		}
+		for _, ts := range m.Timeseries {
+			if len(ts.LabelValues) != len(labels) {
+				continue
+			}
+			match := true
+			for i, lv := range ts.LabelValues {
+				if lv.Value != labels[i] {
+					match = false
+					break
+				}
+			}
+			if match {
+				return ts
+			}
+		}
+	}
+	return nil
+}
+
+func verifyTimeSeriesValue(t *testing.T, data consumerdata.MetricsData, name string, labels []string, value int64) {
+	ts := findTimeSeries(data, name, labels)
+	if ts == nil {
+		t.Errorf("Unable to find metric %s%v", name, labels)
+		return
+	}
+	assert.Equal(t, value, ts.Points[0].GetInt64Value())
+}
+
+func verifyTimeSeriesAbsent(t *testing.T, data consumerdata.MetricsData, name string, labels []string) {
+	if ts := findTimeSeries(data, name, labels); ts != nil {
+		t.Errorf("Expected metric %s%v to be absent, found timeseries: %v", name, labels, ts)
+	}
+}
+
+func TestScraperExportConcurrentKeepsOrder(t *testing.T) {
+	d := &manyContainersDocker{n: 50}
+	sequential := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: sequential,
+		docker:         d,
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+	s.export(context.Background())
+
+	concurrent := &fakeMetricsConsumer{}
+	s.metricConsumer = concurrent
+	s.maxConcurrentScrapes = 8
+	s.export(context.Background())
+
+	seqData := pdatautil.MetricsToMetricsData(sequential.metrics)[0]
+	concData := pdatautil.MetricsToMetricsData(concurrent.metrics)[0]
+	assert.Equal(t, len(seqData.Metrics), len(concData.Metrics))
+	for i := range seqData.Metrics {
+		assert.Equal(t, seqData.Metrics[i].MetricDescriptor.Name, concData.Metrics[i].MetricDescriptor.Name)
+		assert.Equal(t, seqData.Metrics[i].Timeseries[0].LabelValues, concData.Metrics[i].Timeseries[0].LabelValues)
+	}
+	verifyContainerMetricValue(t, concData, "container/memory/usage", "c49", 33)
+}
+
+// manyContainersDocker serves n containers that all have the stats and info
+// of fakeDocker's id1. Each stats call takes statsLatency.
+type manyContainersDocker struct {
+	fakeDocker
+	n            int
+	statsLatency time.Duration
+}
+
+func (d *manyContainersDocker) ContainerList(ctx context.Context, opts types.ContainerListOptions) ([]types.Container, error) {
+	containers := make([]types.Container, d.n)
+	for i := range containers {
+		containers[i] = types.Container{
+			ID:    fmt.Sprintf("id%d", i),
+			Names: []string{fmt.Sprintf("/c%d", i)},
+		}
+	}
+	return containers, nil
+}
+
+func (d *manyContainersDocker) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	time.Sleep(d.statsLatency)
+	return d.fakeDocker.ContainerStats(ctx, "id1", stream)
+}
+
+func (d *manyContainersDocker) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	return d.fakeDocker.ContainerInspect(ctx, "id1")
+}
+
+func BenchmarkScraperExport(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			s := &scraper{
+				startTime:            fakeNow(),
+				metricConsumer:       &fakeMetricsConsumer{},
+				docker:               &manyContainersDocker{n: 300, statsLatency: time.Millisecond},
+				scrapeInterval:       time.Minute,
+				maxConcurrentScrapes: workers,
+				now:                  fakeNow,
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.export(context.Background())
+			}
+		})
+	}
+}
+
 type alwaysFailDocker struct {
 	client.Client
 }
@@ -211,12 +929,122 @@ func (d *alwaysFailDocker) ContainerList(_ context.Context, _ types.ContainerLis
 func TestScraperContinuesOnError(t *testing.T) {
 	s := &scraper{
 		now:            fakeNow,
+		metricConsumer: &fakeMetricsConsumer{},
 		docker:         &alwaysFailDocker{},
 		scrapeInterval: 1 * time.Second,
-		done:           make(chan bool),
 	}
 	s.start()
 	time.Sleep(6 * time.Second)
-	s.stop()
-	assert.GreaterOrEqual(t, s.scrapeCount, uint64(5))
+	assert.NoError(t, s.stop(context.Background()))
+	assert.GreaterOrEqual(t, s.scrapes(), uint64(5))
+}
+
+// blockingDocker blocks ContainerList until release is closed, or until the
+// request context is cancelled if honourContext is set.
+type blockingDocker struct {
+	client.Client
+
+	honourContext bool
+	listing       chan struct{}
+	release       chan struct{}
+	once          sync.Once
+}
+
+func (d *blockingDocker) ContainerList(ctx context.Context, _ types.ContainerListOptions) ([]types.Container, error) {
+	d.once.Do(func() { close(d.listing) })
+	if d.honourContext {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-d.release:
+			return nil, nil
+		}
+	}
+	<-d.release
+	return nil, nil
+}
+
+func newBlockingScraper(d *blockingDocker) *scraper {
+	return &scraper{
+		now:            fakeNow,
+		metricConsumer: &fakeMetricsConsumer{},
+		docker:         d,
+		scrapeInterval: 10 * time.Millisecond,
+		timeout:        time.Hour,
+	}
+}
+
+func TestScraperStopWithoutStart(t *testing.T) {
+	s := newBlockingScraper(&blockingDocker{})
+	assert.NoError(t, s.stop(context.Background()))
+}
+
+func TestScraperStopCancelsScrape(t *testing.T) {
+	d := &blockingDocker{honourContext: true, listing: make(chan struct{}), release: make(chan struct{})}
+	defer close(d.release)
+	s := newBlockingScraper(d)
+	s.start()
+	<-d.listing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, s.stop(ctx))
+	assert.GreaterOrEqual(t, s.scrapes(), uint64(1))
+}
+
+func TestScraperStopHonoursDeadline(t *testing.T) {
+	d := &blockingDocker{listing: make(chan struct{}), release: make(chan struct{})}
+	s := newBlockingScraper(d)
+	s.start()
+	<-d.listing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.stop(ctx))
+
+	// The scrape loop returns once the docker call does.
+	close(d.release)
+	assert.NoError(t, s.stop(context.Background()))
+}
+
+func TestScraperExportSelfMetrics(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &fakeDocker{},
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	// The stats of id3 cannot be read.
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/containers", nil, 2)
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/last_success_time", nil, fakeNow().Unix())
+	// id3 can neither be inspected nor have its stats read.
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/errors", []string{"list"}, 0)
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/errors", []string{"stats"}, 2)
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/errors", []string{"inspect"}, 2)
+	assert.NotNil(t, findTimeSeries(data, "dockerstats/scrape/duration", nil))
+}
+
+func TestScraperExportSelfMetricsListFailure(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &alwaysFailDocker{},
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/containers", nil, 0)
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/errors", []string{"list"}, 1)
+	verifyTimeSeriesAbsent(t, data, "dockerstats/scrape/last_success_time", nil)
 }
@@ -0,0 +1,137 @@
+package dockerstats
+
+import (
+	"sync"
+	"time"
+
+	mpb "github.com/census-instrumentation/opencensus-proto/gen-go/metrics/v1"
+	"github.com/googlecloudplatform/appengine-sidecars-docker/opentelemetry_collector/receiver/metricgenerator"
+)
+
+// Docker API calls whose errors are counted.
+const (
+	callList    = "list"
+	callStats   = "stats"
+	callInspect = "inspect"
+)
+
+// scrapeCalls are the call types reported by dockerstats/scrape/errors.
+var scrapeCalls = []string{callList, callStats, callInspect}
+
+var (
+	callLabel = &mpb.LabelKey{
+		Key:         "call",
+		Description: "Docker API call",
+	}
+
+	scrapeDurationDesc = &mpb.MetricDescriptor{
+		Name:        "dockerstats/scrape/duration",
+		Description: "Duration of the last scrape of the docker stats.",
+		Unit:        "second",
+		Type:        mpb.MetricDescriptor_GAUGE_DOUBLE,
+	}
+	scrapeContainersDesc = &mpb.MetricDescriptor{
+		Name:        "dockerstats/scrape/containers",
+		Description: "Number of containers whose stats were read in the last scrape.",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+	}
+	scrapeErrorsDesc = &mpb.MetricDescriptor{
+		Name:        "dockerstats/scrape/errors",
+		Description: "Number of failed docker API calls, by call type.",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+		LabelKeys:   []*mpb.LabelKey{callLabel},
+	}
+	droppedBatchesDesc = &mpb.MetricDescriptor{
+		Name:        "dockerstats/consumer/dropped_batches",
+		Description: "Number of metric batches dropped because the next consumer kept failing.",
+		Unit:        "Count",
+		Type:        mpb.MetricDescriptor_CUMULATIVE_INT64,
+	}
+	scrapeLastSuccessDesc = &mpb.MetricDescriptor{
+		Name:        "dockerstats/scrape/last_success_time",
+		Description: "Unix time of the last scrape that could list the containers.",
+		Unit:        "second",
+		Type:        mpb.MetricDescriptor_GAUGE_INT64,
+	}
+)
+
+// scrapeStats tracks the health of the scraper itself. Its zero value is
+// ready to use, and it is safe for concurrent use by the scrape workers.
+type scrapeStats struct {
+	mu          sync.Mutex
+	errors      map[string]int64
+	dropped     int64
+	lastSuccess time.Time
+}
+
+// countError records a failed call of the given type.
+func (st *scrapeStats) countError(call string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.errors == nil {
+		st.errors = make(map[string]int64)
+	}
+	st.errors[call]++
+}
+
+// countDropped records a batch dropped after the consumer failed.
+func (st *scrapeStats) countDropped() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.dropped++
+}
+
+// succeeded records a successful scrape at t.
+func (st *scrapeStats) succeeded(t time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lastSuccess = t
+}
+
+// metrics returns the scraper metrics of a scrape that took duration and read
+// the stats of containers containers. Errors are counted since startTime.
+func (st *scrapeStats) metrics(duration time.Duration, containers int, startTime, now time.Time) []*mpb.Metric {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	errors := make([]*mpb.TimeSeries, 0, len(scrapeCalls))
+	for _, call := range scrapeCalls {
+		lv := []*mpb.LabelValue{metricgenerator.MakeLabelValue(call)}
+		errors = append(errors, metricgenerator.MakeInt64TimeSeries(st.errors[call], startTime, now, lv))
+	}
+	metrics := []*mpb.Metric{
+		{
+			MetricDescriptor: scrapeDurationDesc,
+			Timeseries: []*mpb.TimeSeries{
+				metricgenerator.MakeDoubleTimeSeries(duration.Seconds(), startTime, now, nil),
+			},
+		},
+		{
+			MetricDescriptor: scrapeContainersDesc,
+			Timeseries: []*mpb.TimeSeries{
+				metricgenerator.MakeInt64TimeSeries(int64(containers), startTime, now, nil),
+			},
+		},
+		{
+			MetricDescriptor: scrapeErrorsDesc,
+			Timeseries:       errors,
+		},
+		{
+			MetricDescriptor: droppedBatchesDesc,
+			Timeseries: []*mpb.TimeSeries{
+				metricgenerator.MakeInt64TimeSeries(st.dropped, startTime, now, nil),
+			},
+		},
+	}
+	if !st.lastSuccess.IsZero() {
+		metrics = append(metrics, &mpb.Metric{
+			MetricDescriptor: scrapeLastSuccessDesc,
+			Timeseries: []*mpb.TimeSeries{
+				metricgenerator.MakeInt64TimeSeries(st.lastSuccess.Unix(), startTime, now, nil),
+			},
+		})
+	}
+	return metrics
+}
@@ -0,0 +1,107 @@
+package dockerstats
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// resetCounters are the cumulative counters of a container used to detect
+// that its stats were reset without its start time changing.
+type resetCounters struct {
+	cpu   uint64
+	rx    uint64
+	tx    uint64
+	blkio uint64
+}
+
+func countersFromStats(stats *types.StatsJSON) resetCounters {
+	c := resetCounters{cpu: stats.CPUStats.CPUUsage.TotalUsage}
+	for _, nw := range stats.Networks {
+		c.rx += nw.RxBytes
+		c.tx += nw.TxBytes
+	}
+	for _, e := range stats.BlkioStats.IoServiceBytesRecursive {
+		c.blkio += e.Value
+	}
+	return c
+}
+
+// droppedFrom returns whether any of the counters is lower than in prev.
+func (c resetCounters) droppedFrom(prev resetCounters) bool {
+	return c.cpu < prev.cpu || c.rx < prev.rx || c.tx < prev.tx || c.blkio < prev.blkio
+}
+
+// containerStart is the state tracked for the cumulative series of a container.
+type containerStart struct {
+	// startedAt is State.StartedAt as last reported by the docker API.
+	startedAt time.Time
+	// start is the start timestamp of the cumulative series.
+	start    time.Time
+	lastSeen time.Time
+	counters resetCounters
+}
+
+// startTimeTracker keeps the start timestamp of the cumulative series of each
+// container, and moves it forward when the container restarts or its counters
+// go back down.
+type startTimeTracker struct {
+	mu         sync.Mutex
+	containers map[string]*containerStart
+}
+
+func newStartTimeTracker() *startTimeTracker {
+	return &startTimeTracker{containers: make(map[string]*containerStart)}
+}
+
+// startTime records a sample of the container taken at now, and returns the
+// start timestamp to use for its cumulative series. startedAt is zero if the
+// start time of the container is unknown, in which case fallback is used for
+// a container seen for the first time.
+func (t *startTimeTracker) startTime(id string, startedAt time.Time, counters resetCounters, now, fallback time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cs, ok := t.containers[id]
+	if !ok {
+		start := startedAt
+		if start.IsZero() {
+			start = fallback
+		}
+		t.containers[id] = &containerStart{
+			startedAt: startedAt,
+			start:     start,
+			lastSeen:  now,
+			counters:  counters,
+		}
+		return start
+	}
+
+	restarted := !startedAt.IsZero() && !cs.startedAt.IsZero() && !startedAt.Equal(cs.startedAt)
+	switch {
+	case restarted && startedAt.After(cs.lastSeen):
+		cs.start = startedAt
+	case restarted || counters.droppedFrom(cs.counters):
+		// The reset happened at some point since the last sample. Start the
+		// new series right after it so that it does not overlap the old one.
+		cs.start = cs.lastSeen.Add(time.Millisecond)
+	}
+	if !startedAt.IsZero() {
+		cs.startedAt = startedAt
+	}
+	cs.lastSeen = now
+	cs.counters = counters
+	return cs.start
+}
+
+// prune forgets the containers that are not in ids.
+func (t *startTimeTracker) prune(ids map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id := range t.containers {
+		if !ids[id] {
+			delete(t.containers, id)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package dockerstats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+
+	"github.com/googlecloudplatform/appengine-sidecars-docker/opentelemetry_collector/receiver/metricgenerator"
+)
+
+func TestStartTimeTracker(t *testing.T) {
+	tracker := newStartTimeTracker()
+	fallback := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	startedAt := time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC)
+	t0 := fallback.Add(time.Minute)
+
+	// The start time of the container is used when known.
+	start := tracker.startTime("a", startedAt, resetCounters{cpu: 10}, t0, fallback)
+	assert.Equal(t, startedAt, start)
+	// Otherwise, the fallback is used.
+	start = tracker.startTime("b", time.Time{}, resetCounters{cpu: 10}, t0, fallback)
+	assert.Equal(t, fallback, start)
+
+	// Counters going up keep the start time.
+	t1 := t0.Add(time.Minute)
+	assert.Equal(t, startedAt, tracker.startTime("a", startedAt, resetCounters{cpu: 20}, t1, fallback))
+	assert.Equal(t, fallback, tracker.startTime("b", time.Time{}, resetCounters{cpu: 20, rx: 5}, t1, fallback))
+
+	// A counter going down starts a new series after the last sample.
+	t2 := t1.Add(time.Minute)
+	assert.Equal(t, t1.Add(time.Millisecond), tracker.startTime("b", time.Time{}, resetCounters{cpu: 20, rx: 1}, t2, fallback))
+	t3 := t2.Add(time.Minute)
+	assert.Equal(t, t1.Add(time.Millisecond), tracker.startTime("b", time.Time{}, resetCounters{cpu: 30, rx: 2}, t3, fallback))
+
+	// A restart uses the new start time of the container.
+	restartedAt := t2.Add(30 * time.Second)
+	assert.Equal(t, restartedAt, tracker.startTime("a", restartedAt, resetCounters{cpu: 5}, t3, fallback))
+
+	// A restart whose start time is not after the last sample still starts
+	// a new series after the last sample.
+	t4 := t3.Add(time.Minute)
+	assert.Equal(t, t3.Add(time.Millisecond), tracker.startTime("a", restartedAt.Add(time.Second), resetCounters{cpu: 50}, t4, fallback))
+
+	tracker.prune(map[string]bool{"a": true})
+	assert.Contains(t, tracker.containers, "a")
+	assert.NotContains(t, tracker.containers, "b")
+}
+
+func TestCountersFromStats(t *testing.T) {
+	stats := &types.StatsJSON{
+		Stats: types.Stats{
+			CPUStats: types.CPUStats{CPUUsage: types.CPUUsage{TotalUsage: 7}},
+			BlkioStats: types.BlkioStats{
+				IoServiceBytesRecursive: []types.BlkioStatEntry{{Value: 1}, {Value: 2}},
+			},
+		},
+		Networks: map[string]types.NetworkStats{
+			"eth0": {RxBytes: 1, TxBytes: 2},
+			"eth1": {RxBytes: 3, TxBytes: 4},
+		},
+	}
+	assert.Equal(t, resetCounters{cpu: 7, rx: 4, tx: 6, blkio: 3}, countersFromStats(stats))
+}
+
+func TestScraperExportCumulativeStartTimes(t *testing.T) {
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         &fakeDocker{},
+		starts:         newStartTimeTracker(),
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+
+	s.export(context.Background())
+
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	startedAt := metricgenerator.TimeToTimestamp(time.Date(2019, 12, 31, 12, 0, 0, 0, time.UTC))
+	createdAt := metricgenerator.TimeToTimestamp(time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC))
+	scraperStart := metricgenerator.TimeToTimestamp(fakeNow())
+	assert.Equal(t, startedAt, findTimeSeries(data, "container/network/received_bytes", []string{"name1a"}).StartTimestamp)
+	assert.Equal(t, startedAt, findTimeSeries(data, "container/cpu/usage_time", []string{"name1a"}).StartTimestamp)
+	assert.Equal(t, startedAt, findTimeSeries(data, "container/blkio/bytes", []string{"name1a", "8:0", "read"}).StartTimestamp)
+	assert.Equal(t, createdAt, findTimeSeries(data, "container/restart_count", []string{"name1a"}).StartTimestamp)
+	// Gauges keep the scraper start time.
+	assert.Equal(t, scraperStart, findTimeSeries(data, "container/memory/usage", []string{"name1a"}).StartTimestamp)
+	// Without a creation time, restarts are counted since the scraper started.
+	assert.Equal(t, scraperStart, findTimeSeries(data, "container/restart_count", []string{"id2"}).StartTimestamp)
+}
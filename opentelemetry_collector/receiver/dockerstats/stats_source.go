@@ -0,0 +1,73 @@
+package dockerstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// statsSource provides the resource usage stats of running containers.
+type statsSource interface {
+	containerStats(ctx context.Context, id string) (*usageStats, error)
+}
+
+// usageStats is a stats sample of a container, with the fields of the docker
+// API that the vendored types predate.
+type usageStats struct {
+	types.StatsJSON
+	// onlineCPUs is the number of CPUs available to the container, 0 if the
+	// daemon does not report it (API < 1.27).
+	onlineCPUs uint32
+}
+
+// decodeUsageStats decodes a stats sample returned by the docker API.
+func decodeUsageStats(b []byte) (*usageStats, error) {
+	var stats usageStats
+	if err := json.Unmarshal(b, &stats.StatsJSON); err != nil {
+		return nil, err
+	}
+	var extra struct {
+		CPUStats struct {
+			OnlineCPUs uint32 `json:"online_cpus"`
+		} `json:"cpu_stats"`
+	}
+	if err := json.Unmarshal(b, &extra); err != nil {
+		return nil, err
+	}
+	stats.onlineCPUs = extra.CPUStats.OnlineCPUs
+	return &stats, nil
+}
+
+// dockerStatsSource requests a single stats sample from the docker API.
+type dockerStatsSource struct {
+	docker client.ContainerAPIClient
+}
+
+func (d *dockerStatsSource) containerStats(ctx context.Context, id string) (*usageStats, error) {
+	st, err := d.docker.ContainerStats(ctx, id, false /*stream*/)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve stats: %v", err)
+	}
+	defer st.Body.Close()
+
+	b, err := ioutil.ReadAll(st.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats: %v", err)
+	}
+
+	stats, err := decodeUsageStats(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stats JSON: %v", err)
+	}
+	return stats, nil
+}
+
+// containerStats returns the latest sample received on the stats stream of
+// the container.
+func (st *statsStreamer) containerStats(ctx context.Context, id string) (*usageStats, error) {
+	return st.latest(id)
+}
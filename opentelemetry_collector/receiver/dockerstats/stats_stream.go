@@ -0,0 +1,176 @@
+package dockerstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/golang/glog"
+)
+
+// statsStreamer keeps one streaming stats request open per container and
+// caches the latest sample received on each of them, so that a scrape does not
+// have to wait for the docker daemon.
+type statsStreamer struct {
+	docker client.ContainerAPIClient
+	// maxAge is the age after which a sample is rejected, so that a hung
+	// stream or one that fails to reopen is not reported as current.
+	maxAge time.Duration
+	now    func() time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	streams map[string]*statsStream
+}
+
+// statsStream is the streaming stats request of a single container.
+type statsStream struct {
+	cancel context.CancelFunc
+	// done is closed when the stream has ended.
+	done chan struct{}
+
+	mu     sync.Mutex
+	latest *usageStats
+	err    error
+}
+
+func newStatsStreamer(docker client.ContainerAPIClient, maxAge time.Duration) *statsStreamer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &statsStreamer{
+		docker:  docker,
+		maxAge:  maxAge,
+		now:     time.Now,
+		ctx:     ctx,
+		cancel:  cancel,
+		streams: make(map[string]*statsStream),
+	}
+}
+
+// sync starts a stream for every container in ids that does not have a
+// running one, and stops the streams of the containers not in ids.
+func (st *statsStreamer) sync(ids []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.ctx.Err() != nil {
+		return
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+		if stream, ok := st.streams[id]; ok && !stream.ended() {
+			continue
+		}
+		st.streams[id] = st.startStream(id, st.streams[id])
+	}
+	for id, stream := range st.streams {
+		if !wanted[id] {
+			stream.cancel()
+			delete(st.streams, id)
+		}
+	}
+}
+
+// latest returns the last stats sample received for the container. It fails
+// if the sample was read by the daemon more than maxAge ago.
+func (st *statsStreamer) latest(id string) (*usageStats, error) {
+	st.mu.Lock()
+	stream, ok := st.streams[id]
+	st.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no stats stream for container")
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.latest != nil {
+		if age := st.now().Sub(stream.latest.Read); age > st.maxAge {
+			return nil, fmt.Errorf("latest stats sample is stale, read %v ago", age)
+		}
+		return stream.latest, nil
+	}
+	if stream.err != nil {
+		return nil, stream.err
+	}
+	return nil, fmt.Errorf("no stats received yet")
+}
+
+// stop closes all the streams. The streamer cannot be reused afterwards.
+func (st *statsStreamer) stop() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.cancel()
+	st.streams = make(map[string]*statsStream)
+}
+
+// startStream opens a stats stream for the container. The latest sample of
+// the previous stream, if any, is kept until the new stream delivers one.
+func (st *statsStreamer) startStream(id string, previous *statsStream) *statsStream {
+	ctx, cancel := context.WithCancel(st.ctx)
+	stream := &statsStream{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	if previous != nil {
+		stream.latest = previous.latest
+	}
+
+	go func() {
+		defer close(stream.done)
+		defer cancel()
+
+		err := stream.read(ctx, st.docker, id)
+		if ctx.Err() == nil {
+			glog.Warningf("Stats stream for container %s ended: %v", id, err)
+		}
+		stream.mu.Lock()
+		stream.err = err
+		stream.mu.Unlock()
+	}()
+	return stream
+}
+
+// read decodes the samples of the stats stream of the container until it
+// ends or ctx is cancelled.
+func (stream *statsStream) read(ctx context.Context, docker client.ContainerAPIClient, id string) error {
+	resp, err := docker.ContainerStats(ctx, id, true /*stream*/)
+	if err != nil {
+		return fmt.Errorf("failed to open stats stream: %v", err)
+	}
+	// Closing the body unblocks the decoder when the stream is stopped.
+	go func() {
+		<-ctx.Done()
+		resp.Body.Close()
+	}()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("failed to decode stats: %v", err)
+		}
+		stats, err := decodeUsageStats(raw)
+		if err != nil {
+			return fmt.Errorf("failed to decode stats: %v", err)
+		}
+		stream.mu.Lock()
+		stream.latest = stats
+		stream.err = nil
+		stream.mu.Unlock()
+	}
+}
+
+func (stream *statsStream) ended() bool {
+	select {
+	case <-stream.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,169 @@
+package dockerstats
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.opentelemetry.io/collector/consumer/pdatautil"
+)
+
+// streamingDocker serves stats streams whose samples are pushed by the test
+// with send.
+type streamingDocker struct {
+	fakeDocker
+
+	mu      sync.Mutex
+	writers map[string]*io.PipeWriter
+	opened  map[string]int
+}
+
+func newStreamingDocker() *streamingDocker {
+	return &streamingDocker{
+		writers: make(map[string]*io.PipeWriter),
+		opened:  make(map[string]int),
+	}
+}
+
+func (d *streamingDocker) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	r, w := io.Pipe()
+	d.mu.Lock()
+	d.writers[id] = w
+	d.opened[id]++
+	d.mu.Unlock()
+	return types.ContainerStats{Body: r}, nil
+}
+
+func (d *streamingDocker) send(t *testing.T, id string, stats types.StatsJSON) {
+	var w *io.PipeWriter
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		w = d.writers[id]
+		return w != nil
+	}, time.Second, time.Millisecond)
+	require.NoError(t, json.NewEncoder(w).Encode(stats))
+}
+
+func (d *streamingDocker) openCount(id string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.opened[id]
+}
+
+// streamSample returns a stats sample read now with the given memory usage.
+func streamSample(usage uint64) types.StatsJSON {
+	return types.StatsJSON{Stats: types.Stats{Read: time.Now(), MemoryStats: types.MemoryStats{Usage: usage}}}
+}
+
+func waitForSample(t *testing.T, st *statsStreamer, id string, usage uint64) {
+	require.Eventually(t, func() bool {
+		stats, err := st.latest(id)
+		return err == nil && stats.MemoryStats.Usage == usage
+	}, time.Second, time.Millisecond)
+}
+
+func TestStatsStreamerCachesLatestSample(t *testing.T) {
+	d := newStreamingDocker()
+	st := newStatsStreamer(d, time.Minute)
+	defer st.stop()
+
+	st.sync([]string{"id1"})
+	_, err := st.latest("id1")
+	assert.Error(t, err)
+
+	d.send(t, "id1", streamSample(1))
+	waitForSample(t, st, "id1", 1)
+	d.send(t, "id1", streamSample(2))
+	waitForSample(t, st, "id1", 2)
+
+	// A running stream is reused.
+	st.sync([]string{"id1"})
+	assert.Equal(t, 1, d.openCount("id1"))
+}
+
+func TestStatsStreamerFollowsContainers(t *testing.T) {
+	d := newStreamingDocker()
+	st := newStatsStreamer(d, time.Minute)
+	defer st.stop()
+
+	st.sync([]string{"id1", "id2"})
+	d.send(t, "id2", streamSample(5))
+	waitForSample(t, st, "id2", 5)
+
+	st.sync([]string{"id1"})
+	_, err := st.latest("id2")
+	assert.Error(t, err)
+
+	// A stream that ended is restarted, keeping its last sample meanwhile.
+	d.send(t, "id1", streamSample(7))
+	waitForSample(t, st, "id1", 7)
+	d.mu.Lock()
+	d.writers["id1"].Close()
+	d.mu.Unlock()
+	require.Eventually(t, func() bool {
+		st.sync([]string{"id1"})
+		return d.openCount("id1") == 2
+	}, time.Second, time.Millisecond)
+	waitForSample(t, st, "id1", 7)
+}
+
+func TestStatsStreamerRejectsStaleSample(t *testing.T) {
+	d := newStreamingDocker()
+	st := newStatsStreamer(d, 20*time.Second)
+	defer st.stop()
+	now := time.Now()
+	st.now = func() time.Time { return now }
+
+	st.sync([]string{"id1"})
+	d.send(t, "id1", streamSample(1))
+	waitForSample(t, st, "id1", 1)
+
+	// The stream hangs without ending.
+	now = now.Add(time.Minute)
+	_, err := st.latest("id1")
+	assert.Error(t, err)
+	assert.Equal(t, 1, d.openCount("id1"))
+}
+
+func TestScraperExportStreamMode(t *testing.T) {
+	d := newStreamingDocker()
+	st := newStatsStreamer(d, time.Minute)
+	c := &fakeMetricsConsumer{}
+	s := &scraper{
+		startTime:      fakeNow(),
+		metricConsumer: c,
+		docker:         d,
+		stats:          st,
+		streamer:       st,
+		scrapeInterval: 10 * time.Second,
+		now:            fakeNow,
+	}
+	defer s.streamer.stop()
+
+	// The first scrape only starts the streams.
+	s.export(context.Background())
+	d.send(t, "id1", streamSample(123))
+	waitForSample(t, s.streamer, "id1", 123)
+
+	s.export(context.Background())
+	data := pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricValue(t, data, "container/memory/usage", "name1a", 123)
+	verifyContainerMetricAbsent(t, data, "container/memory/usage", "id2")
+	verifyContainerMetricValue(t, data, "container/restart_count", "id2", 5)
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/errors", []string{"stats"}, 5)
+
+	// A stale sample is not reported and is counted as a stats error.
+	st.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	s.export(context.Background())
+	data = pdatautil.MetricsToMetricsData(c.metrics)[0]
+	verifyContainerMetricAbsent(t, data, "container/memory/usage", "name1a")
+	verifyTimeSeriesValue(t, data, "dockerstats/scrape/errors", []string{"stats"}, 8)
+}